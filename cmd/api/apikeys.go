@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+	"time"
+)
+
+// defaultAPIKeyLifetime is how long a key lives when created without expires_at.
+const defaultAPIKeyLifetime = 90 * 24 * time.Hour
+
+// CreateAPIKey creates a key acting as the calling admin , the key is in the response and never shown again.
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:      validator.SanitizeString(input.Name),
+		Scopes:    input.Scopes,
+		CreatedBy: adminID,
+		ExpiresAt: time.Now().Add(defaultAPIKeyLifetime),
+	}
+	if input.ExpiresAt != nil {
+		key.ExpiresAt = *input.ExpiresAt
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	plain, err := app.models.APIKeys.Insert(r.Context(), key, app.actor(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusCreated, envelope{"api_key": key, "key": plain}, nil)
+}
+
+func (app *application) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+}
+
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.Revoke(r.Context(), id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"message": "api key revoked"}, nil)
+}
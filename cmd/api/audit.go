@@ -0,0 +1,39 @@
+package main
+
+import (
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+func (app *application) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read the optional filters from the query string.
+	var filters data.AuditFilters
+	filters.ActorUserID = int64(app.readInt(qs, "actor_user_id", 0, v))
+	filters.Action = app.readString(qs, "action", "")
+	filters.TargetType = app.readString(qs, "target_type", "")
+	filters.TargetID = int64(app.readInt(qs, "target_id", 0, v))
+	filters.From = app.readDate(qs, "from", v)
+	filters.To = app.readDate(qs, "to", v)
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 50, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	if data.ValidateAuditFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+}
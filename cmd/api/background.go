@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// background runs fn in a goroutine tracked by app.wg , so serve() can wait for it on shutdown.
+// A panic is logged instead of taking the whole server down.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+		fn()
+	}()
+}
+
+// checkCardExpiry flags expiring and expired cards and queues their owner notifications , a scheduled job.
+func (app *application) checkCardExpiry(ctx context.Context, _ struct{}) error {
+	flagged, err := app.models.Creditcard.FlagExpiring(ctx, app.config.cards.expiryWindowDays)
+	if err != nil {
+		return err
+	}
+	if flagged > 0 {
+		app.logger.PrintInfoContext(ctx, "credit cards flagged for expiry", map[string]string{
+			"job":     jobCheckCardExpiry,
+			"flagged": fmt.Sprint(flagged),
+		})
+	}
+	return nil
+}
+
+// rebuildSalesRollups recomputes the rollups of the last closed days , a scheduled safety net for the rollups
+// kept in step with every order status change.
+func (app *application) rebuildSalesRollups(ctx context.Context, payload rollupJob) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	days, err := app.models.SalesRollup.Rebuild(ctx, today.AddDate(0, 0, -payload.Days), today.AddDate(0, 0, -1))
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfoContext(ctx, "sales rollups rebuilt", map[string]string{
+		"job":  jobRebuildSalesRollups,
+		"days": fmt.Sprint(days),
+	})
+	return nil
+}
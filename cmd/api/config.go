@@ -2,7 +2,11 @@ package main
 
 import (
 	"flag"
+	"interviewTask/internal/authentication"
+	"interviewTask/internal/data"
+	"interviewTask/internal/jobs"
 	"os"
+	"time"
 )
 
 var cfg config
@@ -12,7 +16,12 @@ type config struct {
 	port            int
 	env             string
 	stripeSecretKey string
-	db              struct {
+	jwt             struct {
+		signingKeyFile       string
+		verificationKeyFiles []string
+		policy               auth.Policy
+	}
+	db struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -23,11 +32,38 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	cards struct {
+		expiryCheckInterval time.Duration
+		expiryWindowDays    int
+	}
+	login data.LoginPolicy
+	mfa   struct {
+		issuer string
+	}
+	webhooks struct {
+		maxAttempts  int
+		timeout      time.Duration
+		allowPrivate bool
+	}
+	metrics struct {
+		port int // 0 disables the metrics server
+	}
+	tracing struct {
+		otlpEndpoint string // "" keeps the spans in process
+		sampleRatio  float64
+	}
+	jobs struct {
+		jobs.Config
+		rollupSchedule jobs.Schedule // nil disables the nightly rebuild
+	}
 }
 
 func init() {
 
 	flag.IntVar(&cfg.port, "port", 4000, "api server port")
+	flag.StringVar(&cfg.tracing.otlpEndpoint, "otlp-endpoint", "", `OTLP/HTTP collector the trace spans are exported to , e.g. "http://localhost:4318" ("" exports nothing)`)
+	flag.Float64Var(&cfg.tracing.sampleRatio, "trace-sample-ratio", 1, "Share of the new traces that are exported , a request continuing a trace follows its caller's choice")
+	flag.IntVar(&cfg.metrics.port, "metrics-port", 9090, "Port of the Prometheus /metrics server , keep it off the public network (0 disables it)")
 	flag.StringVar(&cfg.env, "env", "development", "Environment(development | staging | production)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", dsn, "postgresSql")
 
@@ -38,4 +74,39 @@ func init() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	flag.DurationVar(&cfg.cards.expiryCheckInterval, "card-expiry-interval", 24*time.Hour, "How often stored credit cards are checked for expiry")
+	flag.IntVar(&cfg.cards.expiryWindowDays, "card-expiry-window", 30, "Days before expiry a credit card is flagged as expiring")
+
+	flag.DurationVar(&cfg.login.Window, "login-failure-window", time.Hour, "How long failed logins are remembered")
+	flag.IntVar(&cfg.login.DelayAfter, "login-delay-after", 3, "Failed logins of an account before further attempts are delayed")
+	flag.DurationVar(&cfg.login.BaseDelay, "login-base-delay", 2*time.Second, "First login delay , doubled with every further failure")
+	flag.IntVar(&cfg.login.MaxFailures, "login-max-failures", 10, "Failed logins that temporarily lock an account")
+	flag.DurationVar(&cfg.login.Lockout, "login-lockout", 15*time.Minute, "How long a locked account stays locked")
+	flag.IntVar(&cfg.login.IPMaxFailures, "login-ip-max-failures", 50, "Failed logins from one IP that block it until the failure window moves on")
+
+	flag.StringVar(&cfg.mfa.issuer, "mfa-issuer", "Buy", "Name authenticator apps show next to the account")
+
+	flag.StringVar(&cfg.jwt.policy.Issuer, "jwt-issuer", "buy", "Issuer (iss) of the tokens we sign and accept")
+	flag.StringVar(&cfg.jwt.policy.Audience, "jwt-audience", "buy-api", "Audience (aud) of the tokens we sign and accept")
+	flag.DurationVar(&cfg.jwt.policy.Leeway, "jwt-leeway", 30*time.Second, "Clock skew tolerated when checking token exp, nbf and iat")
+
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 10, "Attempts before a webhook delivery is marked failed")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Timeout of one webhook request")
+	flag.BoolVar(&cfg.webhooks.allowPrivate, "webhook-allow-private", false, "Allow webhook urls on private and loopback addresses (local development)")
+
+	flag.IntVar(&cfg.jobs.Workers, "jobs-workers", 4, "Background jobs run at the same time by this instance")
+	flag.DurationVar(&cfg.jobs.PollInterval, "jobs-poll-interval", time.Second, "How often idle job workers look for due jobs")
+	flag.DurationVar(&cfg.jobs.Timeout, "jobs-timeout", 10*time.Minute, "How long one job may run , a job whose worker died runs again after it")
+	flag.DurationVar(&cfg.jobs.Retention, "jobs-retention", 7*24*time.Hour, "How long succeeded jobs are kept")
+	cfg.jobs.rollupSchedule, _ = jobs.ParseSchedule("30 0 * * *")
+	flag.Func("rollup-schedule", `When the sales rollups of the last two days are rebuilt , a crontab line in UTC or "" to never (default "30 0 * * *")`, func(s string) error {
+		if s == "" {
+			cfg.jobs.rollupSchedule = nil
+			return nil
+		}
+		schedule, err := jobs.ParseSchedule(s)
+		cfg.jobs.rollupSchedule = schedule
+		return err
+	})
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
 	"net/http"
 	"time"
 )
@@ -43,10 +44,47 @@ func (app *application) AddCreditCard(w http.ResponseWriter, r *http.Request) {
 		UserID:         userID,
 		CardToken:      input.CardToken,
 		ExpiryDate:     expiry,
-		CardholderName: input.CardholderName,
+		CardholderName: validator.SanitizeString(input.CardholderName),
 	}
 
-	if err = app.models.Creditcard.Insert(card); err != nil {
+	v := validator.New()
+	if data.ValidateCreditCard(v, card); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	// Attach the card to the user's Stripe Customer so it can be charged later ,
+	// this is also where stripe tells us if the token is not a real card.
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	customerID, err := app.ensureStripeCustomer(r.Context(), user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	attached, err := app.attachStripeCard(r.Context(), customerID, input.CardToken)
+	if err != nil {
+		if errors.Is(err, errInvalidCardToken) {
+			v.AddError("card_token", err.Error())
+			app.validationErrorResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Prefer what the provider knows about the card over the client input.
+	card.StripePaymentMethodID = attached.PaymentMethodID
+	card.Brand = attached.Brand
+	card.Last4 = attached.Last4
+	if !attached.ExpiryDate.IsZero() {
+		card.ExpiryDate = attached.ExpiryDate
+	}
+
+	if err = app.models.Creditcard.Insert(r.Context(), card); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -76,7 +114,24 @@ func (app *application) DeleteCreditCard(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Creditcard.Delete(input.ID, userID)
+	card, err := app.models.Creditcard.GetForUser(r.Context(), input.ID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Detach it from the Stripe Customer first , a failure leaves the card usable instead of orphaned.
+	err = app.detachStripeCard(r.Context(), card.StripePaymentMethodID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Creditcard.Delete(r.Context(), input.ID, userID)
 	if err != nil {
 		// Check if the error is due to a missing record.
 		if errors.Is(err, data.ErrRecordNotFound) {
@@ -90,3 +145,77 @@ func (app *application) DeleteCreditCard(w http.ResponseWriter, r *http.Request)
 	// Respond with a success message.
 	app.writeJson(w, http.StatusOK, envelope{"message": "credit card deleted successfully"}, nil)
 }
+
+func (app *application) ListCreditCards(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	cards, err := app.models.Creditcard.GetAllForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"credit_cards": cards}, nil)
+}
+
+func (app *application) UpdateCreditCard(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// only the default flag can be changed , a card is replaced rather than edited.
+	var input struct {
+		IsDefault *bool `json:"is_default"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.IsDefault != nil && *input.IsDefault, "is_default", "must be true, set another card as default instead")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	card, err := app.models.Creditcard.GetForUser(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if card.Expired() {
+		v.AddError("is_default", "an expired card can not be the default card")
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	card, err = app.models.Creditcard.SetDefault(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"credit_card": card}, nil)
+}
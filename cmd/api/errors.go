@@ -2,11 +2,14 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.logger.PrintErrorContext(r.Context(), err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -27,6 +30,14 @@ func (app *application) rateLimitResponse(w http.ResponseWriter, r *http.Request
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	app.metrics.rateLimited.Inc()
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := fmt.Sprintf("too many failed login attempts , try again in %d seconds", seconds)
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	//app.logError(r, err)
 	message := "server error response"
@@ -59,3 +70,50 @@ func (app *application) accessDeniedResonse(w http.ResponseWriter, r *http.Reque
 	message := "access denied: insufficient privileges"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication required: enable it on your account and log in again"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) mfaAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) missingScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	message := "access denied: api keys can not use this endpoint"
+	if scope != "" {
+		message = "access denied: api key lacks the " + scope + " scope"
+	}
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) orderStatusConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the order's current status does not allow this action"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) insufficientStockResponse(w http.ResponseWriter, r *http.Request) {
+	message := "not enough items in stock to complete the order"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key was already used with a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this Idempotency-Key is still being processed, retry later"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) paymentDeclinedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusPaymentRequired, err.Error())
+}
+
+func (app *application) notVerifiedPurchaserResponse(w http.ResponseWriter, r *http.Request) {
+	message := "only customers who bought this product can review it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
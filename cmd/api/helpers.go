@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (app *application) readIDparam(r *http.Request) (int64, error) {
@@ -97,3 +104,125 @@ func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst int
 	}
 	return nil
 }
+
+// readString returns a string value from the query string, or the default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// readCSV reads a comma separated list from the query string , or the default value if the key is absent.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return strings.Split(s, ",")
+}
+
+// writeCSV writes the rows as a CSV attachment with the given file name.
+func (app *application) writeCSV(w http.ResponseWriter, filename string, header []string, rows [][]string) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+// readInt reads a string value from the query string and converts it to an integer ,
+// failures are recorded in the validator and the default value is returned.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// readFloat is the same as readInt but for decimal values like amounts.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a numeric value")
+		return defaultValue
+	}
+	return f
+}
+
+// readDate parses a "2006-01-02" date from the query string , the zero time means the key was not provided.
+func (app *application) readDate(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		v.AddError(key, "must be a date in YYYY-MM-DD format")
+		return time.Time{}
+	}
+	return t
+}
+
+// readDayRange reads the "from" and "to" calendar days in the given location and returns them as the
+// half-open range [from 00:00, the day after to 00:00) , so the whole "to" day is included.
+// A missing day is returned as the zero time.
+func (app *application) readDayRange(qs url.Values, location *time.Location, v *validator.Validator) (time.Time, time.Time) {
+	var from, to time.Time
+	if s := qs.Get("from"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, location)
+		if err != nil {
+			v.AddError("from", "must be a date in YYYY-MM-DD format")
+		} else {
+			from = t
+		}
+	}
+	if s := qs.Get("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, location)
+		if err != nil {
+			v.AddError("to", "must be a date in YYYY-MM-DD format")
+		} else {
+			to = t.AddDate(0, 0, 1)
+		}
+	}
+	return from, to
+}
+
+// actor describes who is making the request , for the audit log. The user is 0 when the request
+// is not authenticated.
+func (app *application) actor(r *http.Request) data.Actor {
+	userID, _ := r.Context().Value(userContextKey).(int64)
+
+	var apiKeyID int64
+	if key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey); ok {
+		apiKeyID = key.ID
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return data.Actor{UserID: userID, APIKeyID: apiKeyID, IP: ip, UserAgent: r.UserAgent()}
+}
+
+// audit records a security event that is not part of a data change , a failure to record it is logged
+// but does not fail the request.
+func (app *application) audit(r *http.Request, action string, targetID int64, metadata map[string]interface{}) {
+	err := app.models.Audit.Record(context.WithoutCancel(r.Context()), app.actor(r), action, targetID, metadata)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
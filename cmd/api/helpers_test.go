@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"interviewTask/internal/validator"
+)
+
+func TestReadDayRange(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		location *time.Location
+		from, to time.Time
+		errors   []string
+	}{
+		{
+			name:     "to is included",
+			query:    "from=2024-03-01&to=2024-03-02",
+			location: time.UTC,
+			from:     at("2024-03-01T00:00:00Z"),
+			to:       at("2024-03-03T00:00:00Z"),
+		},
+		{
+			name:     "days in the tz timezone",
+			query:    "from=2024-03-02&to=2024-03-02",
+			location: newYork,
+			from:     at("2024-03-02T05:00:00Z"),
+			to:       at("2024-03-03T05:00:00Z"),
+		},
+		{
+			name:     "a day shortened by daylight saving time",
+			query:    "from=2024-03-10&to=2024-03-10",
+			location: newYork,
+			from:     at("2024-03-10T05:00:00Z"),
+			to:       at("2024-03-11T04:00:00Z"),
+		},
+		{
+			name:     "missing days",
+			query:    "",
+			location: time.UTC,
+		},
+		{
+			name:     "malformed days",
+			query:    "from=03/01/2024&to=2024-3-2",
+			location: time.UTC,
+			errors:   []string{"from", "to"},
+		},
+	}
+
+	app := &application{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qs, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := validator.New()
+			from, to := app.readDayRange(qs, tt.location, v)
+
+			if !from.Equal(tt.from) || !to.Equal(tt.to) {
+				t.Errorf("got [%s, %s) , want [%s, %s)", from, to, tt.from, tt.to)
+			}
+			if len(v.Errors) != len(tt.errors) {
+				t.Fatalf("got errors %v , want %v", v.Errors, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.Errors[key]; !ok {
+					t.Errorf("no error for %q in %v", key, v.Errors)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// catalog file formats accepted by the import and produced by the export.
+const (
+	catalogFormatCSV    = "csv"
+	catalogFormatNDJSON = "ndjson"
+)
+
+// catalogColumns is the CSV header of an export , an exported file can be imported back as is.
+var catalogColumns = []string{"sku", "name", "description", "price", "quantity", "low_stock_threshold"}
+
+// an import file may be much larger than a regular JSON body.
+const maxImportBytes = 10 << 20
+
+// exportWriteTimeout is how long the export may wait on the client for one row , it replaces the
+// WriteTimeout of the server which would cut a large catalog off.
+const exportWriteTimeout = 30 * time.Second
+
+// catalogRecord is one row of an import file , a nil field was not provided.
+type catalogRecord struct {
+	SKU               *string  `json:"sku"`
+	Name              *string  `json:"name"`
+	Description       *string  `json:"description"`
+	Price             *float64 `json:"price"`
+	Quantity          *int     `json:"quantity"`
+	LowStockThreshold *int     `json:"low_stock_threshold"`
+
+	parseErrors map[string]string // CSV cells that are not numbers
+}
+
+func (rec *catalogRecord) parseError(column string) {
+	if rec.parseErrors == nil {
+		rec.parseErrors = make(map[string]string)
+	}
+	rec.parseErrors[column] = "must be a number"
+}
+
+// importRowErrors are the validation errors of one row , Row is 1-based and does not count the CSV header.
+type importRowErrors struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// catalogFormat picks the file format from the format query parameter , falling back to the content type.
+func (app *application) catalogFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson":
+		return catalogFormatNDJSON
+	case "text/csv", "":
+		return catalogFormatCSV
+	}
+	return mediaType
+}
+
+func (app *application) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	format := app.catalogFormat(r)
+	dryRun := app.readString(r.URL.Query(), "dry_run", "false") == "true"
+	v.Check(validator.In(format, catalogFormatCSV, catalogFormatNDJSON), "format", "must be csv or ndjson")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var records []catalogRecord
+	var err error
+	if format == catalogFormatCSV {
+		records, err = readCatalogCSV(body)
+	} else {
+		records, err = readCatalogNDJSON(body)
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if len(records) == 0 {
+		app.badRequestResponse(w, r, errors.New("import file has no rows"))
+		return
+	}
+
+	// validate every row , only the valid ones are handed to the model.
+	rowErrors := []importRowErrors{}
+	products := make([]*data.Product, 0, len(records))
+	rowNumbers := make([]int, 0, len(records))
+	for i, record := range records {
+		product, errs := record.product()
+		if errs != nil {
+			rowErrors = append(rowErrors, importRowErrors{Row: i + 1, Errors: errs})
+			continue
+		}
+		products = append(products, product)
+		rowNumbers = append(rowNumbers, i+1)
+	}
+
+	if len(rowErrors) > 0 && !dryRun {
+		app.writeJson(w, http.StatusUnprocessableEntity, envelope{"error": "import has invalid rows , nothing was imported", "rows": rowErrors}, nil)
+		return
+	}
+
+	result := &data.ImportResult{}
+	if len(products) > 0 {
+		result, err = app.models.Product.Import(r.Context(), products, app.actor(r), dryRun)
+		if err != nil {
+			var rowErr *data.ImportRowError
+			if errors.As(err, &rowErr) {
+				rowErrors = append(rowErrors, importRowErrors{Row: rowNumbers[rowErr.Row-1], Errors: map[string]string{"row": rowErr.Message}})
+				app.writeJson(w, http.StatusUnprocessableEntity, envelope{"error": "import has invalid rows , nothing was imported", "rows": rowErrors}, nil)
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"dry_run": dryRun, "result": result, "rows": rowErrors}, nil)
+}
+
+// product converts the record and validates it with the same rules as CreateProduct.
+func (rec catalogRecord) product() (*data.Product, map[string]string) {
+	if rec.parseErrors != nil {
+		return nil, rec.parseErrors
+	}
+
+	v := validator.New()
+	v.Check(rec.Name != nil, "name", "must be provided")
+	v.Check(rec.Description != nil, "description", "must be provided")
+	v.Check(rec.Price != nil, "price", "must be provided")
+	v.Check(rec.Quantity != nil, "quantity", "must be provided")
+	if !v.Valid() {
+		return nil, v.Errors
+	}
+
+	product := &data.Product{
+		Name:           *rec.Name,
+		Description:    *rec.Description,
+		Price:          *rec.Price,
+		InventoryCount: *rec.Quantity,
+	}
+	if rec.SKU != nil {
+		product.SKU = strings.TrimSpace(*rec.SKU)
+	}
+	if rec.LowStockThreshold != nil {
+		product.LowStockThreshold = *rec.LowStockThreshold
+	}
+
+	data.ValidateProduct(v, product)
+	if !v.Valid() {
+		return nil, v.Errors
+	}
+	return product, nil
+}
+
+// readCatalogCSV reads a CSV file whose header names the columns , sku and low_stock_threshold may be
+// left out. description is required like in CreateProduct.
+func readCatalogCSV(body io.Reader) ([]catalogRecord, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("import file must not be empty")
+		}
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.In(name, catalogColumns...) {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"name", "description", "price", "quantity"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	records := []catalogRecord{}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var rec catalogRecord
+		for name, i := range columns {
+			value := row[i]
+			switch name {
+			case "sku":
+				rec.SKU = &value
+			case "name":
+				rec.Name = &value
+			case "description":
+				rec.Description = &value
+			case "price":
+				if price, err := strconv.ParseFloat(value, 64); err == nil {
+					rec.Price = &price
+				} else {
+					rec.parseError(name)
+				}
+			case "quantity":
+				if quantity, err := strconv.Atoi(value); err == nil {
+					rec.Quantity = &quantity
+				} else {
+					rec.parseError(name)
+				}
+			case "low_stock_threshold":
+				if value == "" {
+					continue
+				}
+				if threshold, err := strconv.Atoi(value); err == nil {
+					rec.LowStockThreshold = &threshold
+				} else {
+					rec.parseError(name)
+				}
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// readCatalogNDJSON reads one JSON object per line , blank lines are skipped.
+func readCatalogNDJSON(body io.Reader) ([]catalogRecord, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	records := []catalogRecord{}
+	for line := 1; scanner.Scan(); line++ {
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+
+		var rec catalogRecord
+		if err := dec.Decode(&rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ExportProducts streams the catalog , rows are written as they are read so the catalog is never held in memory.
+func (app *application) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	format := app.readString(r.URL.Query(), "format", catalogFormatCSV)
+
+	v := validator.New()
+	v.Check(validator.In(format, catalogFormatCSV, catalogFormatNDJSON), "format", "must be csv or ndjson")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	// the deadline is pushed back before every row , only a client that stops reading times out.
+	rc := http.NewResponseController(w)
+	extendDeadline := func() error {
+		err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if errors.Is(err, http.ErrNotSupported) {
+			return nil
+		}
+		return err
+	}
+	if err := extendDeadline(); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var write func(*data.Product) error
+	var flush func() error
+	if format == catalogFormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="products.csv"`)
+
+		cw := csv.NewWriter(w)
+		if err := cw.Write(catalogColumns); err != nil {
+			app.logError(r, err)
+			return
+		}
+		write = func(p *data.Product) error {
+			if err := extendDeadline(); err != nil {
+				return err
+			}
+			return cw.Write([]string{
+				p.SKU,
+				p.Name,
+				p.Description,
+				strconv.FormatFloat(p.Price, 'f', -1, 64),
+				strconv.Itoa(p.InventoryCount),
+				strconv.Itoa(p.LowStockThreshold),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="products.ndjson"`)
+
+		enc := json.NewEncoder(w)
+		write = func(p *data.Product) error {
+			if err := extendDeadline(); err != nil {
+				return err
+			}
+			return enc.Encode(catalogRecord{
+				SKU:               &p.SKU,
+				Name:              &p.Name,
+				Description:       &p.Description,
+				Price:             &p.Price,
+				Quantity:          &p.InventoryCount,
+				LowStockThreshold: &p.LowStockThreshold,
+			})
+		}
+		flush = func() error { return nil }
+	}
+
+	// the status line is already sent , a failure half way can only be logged.
+	err := app.models.Product.Export(r.Context(), write)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
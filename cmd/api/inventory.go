@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+func (app *application) CreateStockAdjustment(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		QuantityDelta int    `json:"quantity_delta"`
+		Reason        string `json:"reason"`
+		Note          string `json:"note"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	movement := &data.InventoryMovement{
+		ProductID:     productID,
+		QuantityDelta: input.QuantityDelta,
+		Reason:        input.Reason,
+		ActorUserID:   &userID,
+		Note:          validator.SanitizeString(input.Note),
+	}
+
+	v := validator.New()
+	if data.ValidateStockAdjustment(v, movement); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Inventory.Adjust(r.Context(), movement, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrInsufficientStock):
+			v.AddError("quantity_delta", "would take the stock below zero")
+			app.validationErrorResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	product, err := app.models.Product.GetByID(r.Context(), productID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusCreated, envelope{"movement": movement, "product": product}, nil)
+}
+
+func (app *application) ListStockMovements(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 50, v)
+	filters.Sort = "-created_at"
+	filters.SortSafelist = []string{"-created_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	// the balance tells whether inventory_count still matches the ledger.
+	balance, err := app.models.Inventory.GetBalance(r.Context(), productID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movements, metadata, err := app.models.Inventory.GetMovements(r.Context(), productID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"balance": balance, "movements": movements, "metadata": metadata}, nil)
+}
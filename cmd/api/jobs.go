@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/jobs"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+// job kinds of the scheduled jobs , the webhook and notification kinds live in data as the data layer queues them.
+const (
+	jobCheckCardExpiry     = "cards.check_expiry"
+	jobRebuildSalesRollups = "sales.rebuild_rollups"
+)
+
+// rollupJob is the payload of jobRebuildSalesRollups.
+type rollupJob struct {
+	Days int `json:"days"` // closed days to rebuild , yesterday is 1
+}
+
+// registerJobs gives the queue its handlers and schedules , before serve() runs it.
+func (app *application) registerJobs() {
+	client := newWebhookClient(app.config.webhooks.timeout, app.config.webhooks.allowPrivate)
+	jobs.Register(app.jobs, data.JobDeliverWebhook, app.deliverWebhook(client))
+	jobs.Register(app.jobs, data.JobSendNotification, app.sendNotification)
+
+	jobs.Register(app.jobs, jobCheckCardExpiry, app.checkCardExpiry)
+	app.jobs.Schedule(jobCheckCardExpiry, jobs.Every(app.config.cards.expiryCheckInterval), jobCheckCardExpiry, nil)
+
+	jobs.Register(app.jobs, jobRebuildSalesRollups, app.rebuildSalesRollups)
+	if app.config.jobs.rollupSchedule != nil {
+		app.jobs.Schedule(jobRebuildSalesRollups, app.config.jobs.rollupSchedule, jobRebuildSalesRollups, rollupJob{Days: 2})
+	}
+}
+
+// ListJobs lists the job queue , ?status=dead is the dead letter queue.
+func (app *application) ListJobs(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.JobFilters
+	filters.Status = app.readString(qs, "status", "")
+	filters.Kind = app.readString(qs, "kind", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 50, v)
+	filters.Sort = "-created_at"
+	filters.SortSafelist = []string{"-created_at"}
+
+	if data.ValidateJobFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	list, metadata, err := app.models.Jobs.GetAll(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"jobs": list, "metadata": metadata}, nil)
+}
+
+// RetryJob runs a dead job again.
+func (app *application) RetryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	job, err := app.models.Jobs.Retry(r.Context(), id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrJobNotDead), errors.Is(err, data.ErrJobAlreadyQueued):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"job": job}, nil)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"interviewTask/internal/authentication"
+	"net/http"
+)
+
+// JWKS publishes the public keys our tokens are verified with , other services fetch it to check
+// tokens on their own. It lists the previous keys too while a rotation is in progress.
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{"Cache-Control": []string{"public, max-age=300"}}
+	err := app.writeJson(w, http.StatusOK, envelope{"keys": auth.JWKS()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,26 +3,54 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"flag"
+	"fmt"
+	"github.com/XSAM/otelsql"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"interviewTask/internal/authentication"
 	"interviewTask/internal/data"
+	"interviewTask/internal/jobs"
 	"interviewTask/internal/jsonlog"
+	"interviewTask/internal/mailer"
+	"interviewTask/internal/oauth"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // the sales report takes IANA timezones , the runtime image has no zoneinfo
 )
 
 const version = "1.0.0"
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	oauth   map[string]oauth.Provider // identity providers by name
+	jobs    *jobs.Queue
+	mailer  mailer.Mailer
+	metrics *metrics
+	wg      sync.WaitGroup
 }
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	// every query is a span under the span of the request or job whose context it runs with , the queries
+	// outside of one (the job queue polling) are left out.
+	db, err := otelsql.Open("postgres", cfg.db.dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}))
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +70,31 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
+// newMailer sends through the SMTP server of SMTP_HOST , without one the emails only go to the log.
+func newMailer(logger *jsonlog.Logger) mailer.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		logger.PrintInfo("SMTP_HOST is not set , emails are logged instead of sent", nil)
+		return mailer.Log{Logger: logger}
+	}
+
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 587
+	}
+	sender := os.Getenv("SMTP_SENDER")
+	if sender == "" {
+		sender = "Buy <no-reply@example.com>"
+	}
+	return mailer.SMTP{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		Sender:   sender,
+	}
+}
+
 func main() {
 
 	err := godotenv.Load()
@@ -51,8 +104,32 @@ func main() {
 
 	flag.Parse()
 	cfg.stripeSecretKey = os.Getenv("STRIPE_SECRET_KEY")
+	cfg.jwt.signingKeyFile = os.Getenv("JWT_SIGNING_KEY_FILE")
+	if files := os.Getenv("JWT_VERIFICATION_KEY_FILES"); files != "" {
+		cfg.jwt.verificationKeyFiles = strings.Split(files, ",")
+	}
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// refuse to start without a key , every token would be rejected (or worse , signed with nothing).
+	keys, err := auth.LoadKeys(cfg.jwt.signingKeyFile, cfg.jwt.verificationKeyFiles)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	auth.UseKeys(keys)
+	auth.UsePolicy(cfg.jwt.policy)
+	logger.PrintInfo("jwt keys loaded", map[string]string{"signing_kid": keys.SigningKeyID()})
+
+	shutdownTracing, err := setupTracing(cfg.tracing.otlpEndpoint, cfg.tracing.sampleRatio)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	// flush the spans still buffered on the way out.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -60,11 +137,25 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	baseURL := os.Getenv("OIDC_REDIRECT_BASE_URL")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%d", cfg.port)
+	}
+	providers, err := loadOAuthProviders(baseURL)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModel(db),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModel(db),
+		oauth:   providers,
+		jobs:    jobs.New(db, cfg.jobs.Config, logger),
+		mailer:  newMailer(logger),
+		metrics: newMetrics(db),
 	}
+	app.registerJobs()
 
 	err = app.serve()
 	if err != nil {
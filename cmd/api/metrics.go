@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the Prometheus collectors of the API , served on -metrics-port and never on the public port.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+
+	rateLimited       prometheus.Counter
+	ordersCreated     prometheus.Counter
+	paymentFailures   *prometheus.CounterVec
+	stripeEvents      *prometheus.CounterVec
+	webhookDeliveries *prometheus.CounterVec
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buy_http_requests_total",
+			Help: "HTTP requests by route pattern, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "buy_http_request_duration_seconds",
+			Help:    "HTTP request latency by route pattern, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "buy_http_requests_in_flight",
+			Help: "HTTP requests being served.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "buy_rate_limit_rejections_total",
+			Help: "Login attempts rejected with 429 after too many failures.",
+		}),
+		ordersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "buy_orders_created_total",
+			Help: "Orders created by checkout.",
+		}),
+		paymentFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buy_payment_failures_total",
+			Help: "Failed payments by source , checkout for declined cards and webhook for Stripe payment_failed events.",
+		}, []string{"source"}),
+		stripeEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buy_stripe_webhook_events_total",
+			Help: "Verified Stripe webhook events by type.",
+		}, []string{"type"}),
+		webhookDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buy_webhook_delivery_attempts_total",
+			Help: "Outbound webhook delivery attempts by event type and result.",
+		}, []string{"event", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "buy"),
+		m.requests, m.duration, m.inFlight,
+		m.rateLimited, m.ordersCreated, m.paymentFailures, m.stripeEvents, m.webhookDeliveries,
+	)
+	return m
+}
+
+// handler serves the metrics in the Prometheus text format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records the requests of one route , route is its pattern so /user/orders/1 and /user/orders/2
+// are counted together.
+func (m *metrics) instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		status := strconv.Itoa(sw.status)
+		m.requests.WithLabelValues(route, r.Method, status).Inc()
+		m.duration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath , to flush or set deadlines.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// instrumentedRouter registers every handler with its metrics and trace span under its route pattern.
+type instrumentedRouter struct {
+	*httprouter.Router
+	metrics *metrics
+}
+
+func (ir instrumentedRouter) Handler(method, path string, handler http.Handler) {
+	ir.Router.Handler(method, path, ir.instrument(path, handler))
+}
+
+// instrument wraps a handler of the route with its metrics and span.
+func (ir instrumentedRouter) instrument(route string, handler http.Handler) http.Handler {
+	return ir.metrics.instrument(route, traceRequests(route, handler))
+}
+
+func (ir instrumentedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	ir.Handler(method, path, handler)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"interviewTask/internal/authentication"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+	"time"
+)
+
+// two-factor authentication with an authenticator app (TOTP) , the user enrols a secret, confirms it
+// with a first code and from then on logs in with the password and a code (or a recovery code).
+
+// LoginUserMFA exchanges the token from LoginUser and a code for the access token.
+func (app *application) LoginUserMFA(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.MFAToken != "", "mfa_token", "must be provided")
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := auth.ValidateMFAToken(input.MFAToken)
+	if err != nil {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// wrong codes count as failed logins , so they are throttled and lock the account like wrong passwords.
+	ip := app.actor(r).IP
+	attempt, retryAfter, err := app.models.Logins.Begin(r.Context(), user.Email, ip, app.config.login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.audit(r, data.AuditLoginFailed, user.ID, map[string]interface{}{"email": user.Email, "reason": "throttled"})
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	totp, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if totp == nil || !totp.Confirmed() {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	method := "totp"
+	var valid bool
+	if input.Code != "" {
+		valid, err = app.checkTOTP(r.Context(), totp, input.Code)
+	} else {
+		method = "recovery_code"
+		valid, err = app.models.MFA.UseRecoveryCode(r.Context(), user.ID, input.RecoveryCode, app.actor(r))
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		failures, err := app.models.Logins.RecordFailure(context.WithoutCancel(r.Context()), attempt, user.ID, app.config.login)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.audit(r, data.AuditLoginFailed, user.ID, map[string]interface{}{"email": user.Email, "reason": "wrong " + method, "failures": failures})
+		if failures == app.config.login.MaxFailures {
+			app.audit(r, data.AuditAccountLocked, user.ID, map[string]interface{}{"lockout": app.config.login.Lockout.String()})
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.completeLogin(w, r, user, method)
+}
+
+// checkTOTP validates the code against the secret and spends its time step , a code is accepted once.
+func (app *application) checkTOTP(ctx context.Context, totp *data.TOTP, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.models.MFA.UseStep(ctx, totp.UserID, step)
+}
+
+// EnrollTOTP creates a new secret for the user , it is only enforced once confirmed with ConfirmTOTP.
+// Enrolling again before that replaces the secret.
+func (app *application) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enroll(r.Context(), userID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			app.mfaAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusCreated, envelope{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(app.config.mfa.issuer, user.Email, secret),
+	}, nil)
+}
+
+// ConfirmTOTP turns the second factor on with a first code from the app and returns the recovery codes ,
+// the only time they are shown.
+func (app *application) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	totp, err := app.models.MFA.GetTOTP(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("no authenticator app enrolled: call POST /user/mfa/totp first"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if totp.Confirmed() {
+		app.mfaAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	var step int64
+	ok = app.verifyCode(w, r, userID, "wrong totp", func() (bool, error) {
+		var valid bool
+		step, valid = auth.ValidateTOTP(totp.Secret, input.Code, time.Now())
+		return valid, nil
+	})
+	if !ok {
+		return
+	}
+
+	codes, err := app.models.MFA.Confirm(r.Context(), userID, step, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			app.mfaAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes , it needs a current code from the app.
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	totp, ok := app.requireTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.models.MFA.RegenerateRecoveryCodes(r.Context(), totp.UserID, app.actor(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+}
+
+// DisableTOTP turns the second factor off , it needs a current code from the app.
+// Admins lose access to the admin routes until they enrol again.
+func (app *application) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	totp, ok := app.requireTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.MFA.Disable(r.Context(), totp.UserID, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+}
+
+// requireTOTPCode reads {"code": ...} and checks it against the user's confirmed secret , it writes
+// the error response and returns false when the request can not go on.
+func (app *application) requireTOTPCode(w http.ResponseWriter, r *http.Request) (*data.TOTP, bool) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return nil, false
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	totp, err := app.models.MFA.GetTOTP(r.Context(), userID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if totp == nil || !totp.Confirmed() {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	ok = app.verifyCode(w, r, userID, "wrong totp", func() (bool, error) {
+		return app.checkTOTP(r.Context(), totp, input.Code)
+	})
+	if !ok {
+		return nil, false
+	}
+	return totp, true
+}
+
+// verifyCode runs check , the code check of a signed in user , through the login throttle. Wrong codes
+// count as failed logins so guessing them is delayed and locks the account like guessing passwords. It
+// writes the error response and returns false when the request can not go on.
+func (app *application) verifyCode(w http.ResponseWriter, r *http.Request, userID int64, reason string, check func() (bool, error)) bool {
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	attempt, retryAfter, err := app.models.Logins.Begin(r.Context(), user.Email, app.actor(r).IP, app.config.login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if attempt == nil {
+		app.audit(r, data.AuditLoginFailed, user.ID, map[string]interface{}{"email": user.Email, "reason": "throttled"})
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return false
+	}
+
+	valid, err := check()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !valid {
+		failures, err := app.models.Logins.RecordFailure(context.WithoutCancel(r.Context()), attempt, user.ID, app.config.login)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		app.audit(r, data.AuditLoginFailed, user.ID, map[string]interface{}{"email": user.Email, "reason": reason, "failures": failures})
+		if failures == app.config.login.MaxFailures {
+			app.audit(r, data.AuditAccountLocked, user.ID, map[string]interface{}{"lockout": app.config.login.Lockout.String()})
+		}
+		app.validationErrorResponse(w, r, map[string]string{"code": "invalid code"})
+		return false
+	}
+
+	err = app.models.Logins.Forget(r.Context(), attempt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	return true
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"fmt"
-	"github.com/golang-jwt/jwt/v4"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"interviewTask/internal/authentication"
+	"interviewTask/internal/data"
+	"io"
 	"net/http"
 	"strings"
 )
@@ -14,56 +18,66 @@ type contextKey string
 const (
 	userContextKey = contextKey("userId")
 	roleContextKey = contextKey("role")
+	mfaContextKey  = contextKey("mfa")
+
+	sessionContextKey = contextKey("session")
+	apiKeyContextKey  = contextKey("apiKey")
+
+	idempotencyKeyContextKey = contextKey("idempotencyKey")
 )
 
+// AuthMiddleware authenticates a user with "Authorization: Bearer <jwt>" or an integration with
+// "Authorization: ApiKey <key>". An API key acts as the admin who created it , RequireScope decides
+// which routes it reaches.
 func (app *application) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract the token from the Authorization header.
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.logger.PrintInfo(fmt.Sprintf("authrization header is %s ", authHeader), nil)
+		// Extract the credentials from the Authorization header.
+		scheme, tokenStr, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || (scheme != "Bearer" && scheme != "ApiKey") || tokenStr == "" {
+			app.logger.PrintInfo("missing or malformed authorization header", nil)
 			app.invalidCredentialsResponse(w, r)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.logger.PrintInfo(fmt.Sprintf("parts is %s %s %s %s ", parts[0], parts[1], parts[2], parts[3]), nil)
-
-			app.invalidCredentialsResponse(w, r)
-			return
-		}
-		tokenStr := parts[1]
+		if scheme == "ApiKey" {
+			key, err := app.models.APIKeys.Authenticate(r.Context(), tokenStr)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrInvalidAPIKey):
+					app.logger.PrintInfo("invalid api key", nil)
+					app.invalidCredentialsResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
 
-		// Validate the token.
-		token, err := auth.ValidateToken(tokenStr)
-		if err != nil || !token.Valid {
-			app.logger.PrintInfo(fmt.Sprintf("token is %s ", token), nil)
-			app.invalidCredentialsResponse(w, r)
+			ctx := context.WithValue(r.Context(), userContextKey, key.CreatedBy)
+			ctx = context.WithValue(ctx, roleContextKey, key.Role)
+			ctx = context.WithValue(ctx, apiKeyContextKey, key)
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		// Extract claims.
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			app.logger.PrintInfo("claims check ", nil)
+		// Validate the token and its claims.
+		claims, err := auth.Authenticate(tokenStr)
+		if err != nil {
+			app.logger.PrintInfo("invalid token", map[string]string{"error": err.Error()})
 			app.invalidCredentialsResponse(w, r)
 			return
 		}
-
-		// Extract the user ID (as "sub") and role.
-		sub, ok := claims["sub"].(float64)
-		if !ok {
-			app.logger.PrintInfo("claims sub", nil)
-
+		userID, err := claims.UserID()
+		if err != nil {
+			app.logger.PrintInfo("invalid token subject", nil)
 			app.invalidCredentialsResponse(w, r)
 			return
 		}
-		role, _ := claims["role"].(string)
 
-		// Set the userID and role in the request context for downstream handlers.
-		ctx := context.WithValue(r.Context(), userContextKey, int64(sub))
-		ctx = context.WithValue(ctx, roleContextKey, role)
+		// Set the userID, role and session in the request context for downstream handlers.
+		ctx := context.WithValue(r.Context(), userContextKey, userID)
+		ctx = context.WithValue(ctx, roleContextKey, claims.Role)
+		ctx = context.WithValue(ctx, mfaContextKey, claims.MFA)
+		ctx = context.WithValue(ctx, sessionContextKey, claims.SessionID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -75,6 +89,12 @@ func (app *application) RequireRole(requiredRole string) func(http.Handler) http
 			// Retrieve the role from the context.
 			role, ok := r.Context().Value(roleContextKey).(string)
 			if !ok || role != requiredRole {
+				app.audit(r, data.AuditAccessDenied, 0, map[string]interface{}{
+					"method":        r.Method,
+					"path":          r.URL.Path,
+					"required_role": requiredRole,
+					"role":          role,
+				})
 				app.accessDeniedResonse(w, r)
 				return
 			}
@@ -82,3 +102,161 @@ func (app *application) RequireRole(requiredRole string) func(http.Handler) http
 		})
 	}
 }
+
+// RequireMFA only lets through tokens issued after a second factor , it runs after AuthMiddleware.
+// Users without one can still reach their own account to enrol. API keys are created by admins who
+// passed it and are let through.
+func (app *application) RequireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mfa, _ := r.Context().Value(mfaContextKey).(bool)
+		_, apiKey := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+		if !mfa && !apiKey {
+			app.audit(r, data.AuditAccessDenied, 0, map[string]interface{}{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"reason": "second factor required",
+			})
+			app.mfaRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope lets an API key through only if it has the scope , user tokens are not affected.
+// An empty scope keeps API keys out altogether.
+func (app *application) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+			if ok && (scope == "" || !key.HasScope(scope)) {
+				app.audit(r, data.AuditAccessDenied, 0, map[string]interface{}{
+					"method":         r.Method,
+					"path":           r.URL.Path,
+					"required_scope": scope,
+					"api_key":        key.Prefix,
+				})
+				app.missingScopeResponse(w, r, scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// responseRecorder passes the response through to the client and keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// Idempotency honours the Idempotency-Key header on POST requests , it must run after AuthMiddleware
+// because keys are scoped to the user. The first request with a key is executed and its response stored,
+// a repeat with the same body gets the stored response back and a repeat with another body is rejected.
+func (app *application) Idempotency(next http.Handler) http.Handler {
+	return app.IdempotencyLimit(1_048_500)(next)
+}
+
+// IdempotencyLimit is Idempotency for routes whose body may be larger than a JSON body , the body is read
+// up to maxBytes to fingerprint it.
+func (app *application) IdempotencyLimit(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return app.idempotency(maxBytes, next)
+	}
+}
+
+func (app *application) idempotency(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key must not exceed 255 characters"))
+			return
+		}
+
+		userID, ok := r.Context().Value(userContextKey).(int64)
+		if !ok {
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+
+		// fingerprint the request , then put the body back for the handler.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, created, err := app.models.Idempotency.Begin(r.Context(), userID, key, fingerprint)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyMismatch):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case errors.Is(err, data.ErrIdempotencyKeyInProgress):
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// replay the stored response.
+		if !created {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.ResponseStatus)
+			w.Write(record.ResponseBody)
+			return
+		}
+
+		// a handler that panics must not leave the key in progress until it expires , release it and let the
+		// panic go on.
+		defer func() {
+			if p := recover(); p != nil {
+				if err := app.models.Idempotency.Release(context.WithoutCancel(r.Context()), record.ID); err != nil {
+					app.logError(r, err)
+				}
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), idempotencyKeyContextKey, key)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		// server errors are not stored so the client can retry them with the same key. The client may have
+		// hung up by now , the key must still be settled.
+		ctx = context.WithoutCancel(r.Context())
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			err = app.models.Idempotency.Release(ctx, record.ID)
+		} else {
+			err = app.models.Idempotency.Complete(ctx, record.ID, rec.status, rec.body.Bytes())
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/jobs"
+	"interviewTask/internal/mailer"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+// sendNotification is the handler of the data.JobSendNotification jobs , it emails one notification unless
+// it was sent already , its owner turned the email off or its kind has no email.
+func (app *application) sendNotification(ctx context.Context, job data.NotificationJob) error {
+	email, err := app.models.Notifications.PendingEmail(ctx, job.NotificationID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	// UseNumber keeps ids such as 1000000 from printing as 1e+06.
+	var payload map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(email.Payload))
+	dec.UseNumber()
+	if err = dec.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	msg, err := mailer.Render(email.Kind, email.Email, mailer.TemplateData{FirstName: email.FirstName, Data: payload})
+	if err != nil {
+		if errors.Is(err, mailer.ErrNoTemplate) {
+			return nil
+		}
+		return jobs.Permanent(err)
+	}
+
+	if err = app.mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+	return app.models.Notifications.MarkSent(ctx, email.NotificationID)
+}
+
+// ListNotifications is the inbox of the logged in user , ?unread=true leaves out the read notifications.
+func (app *application) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	unread := app.readString(qs, "unread", "false")
+	v.Check(validator.In(unread, "true", "false"), "unread", "must be true or false")
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "-created_at"
+	filters.SortSafelist = []string{"-created_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	list, unreadCount, metadata, err := app.models.Notifications.GetForUser(r.Context(), userID, unread == "true", filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"notifications": list, "unread_count": unreadCount, "metadata": metadata}, nil)
+}
+
+// UpdateNotification marks a notification of the logged in user read or unread.
+func (app *application) UpdateNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Read *bool `json:"read"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Read != nil, "read", "must be provided")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	n, err := app.models.Notifications.SetRead(r.Context(), userID, id, *input.Read)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"notification": n}, nil)
+}
+
+// MarkAllNotificationsRead empties the unread part of the inbox.
+func (app *application) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	marked, err := app.models.Notifications.MarkAllRead(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"marked_read": marked}, nil)
+}
+
+// GetNotificationPreferences returns the channels of every notification kind for the logged in user.
+func (app *application) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	prefs, err := app.models.Notifications.GetPreferences(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"preferences": prefs}, nil)
+}
+
+// UpdateNotificationPreferences sets the channels of the listed kinds , the kinds left out keep theirs.
+func (app *application) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Preferences []data.NotificationPreference `json:"preferences"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateNotificationPreferences(v, input.Preferences); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	if err = app.models.Notifications.SetPreferences(r.Context(), userID, input.Preferences); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	prefs, err := app.models.Notifications.GetPreferences(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"preferences": prefs}, nil)
+}
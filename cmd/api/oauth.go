@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interviewTask/internal/data"
+	"interviewTask/internal/oauth"
+	"interviewTask/internal/validator"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// sign in with an external OpenID Connect provider. /start sends the browser to the provider and
+// /callback turns its answer into our tokens , exactly like a password login.
+
+// oauthStateExpiry is how long the user has to sign in at the provider.
+const oauthStateExpiry = 10 * time.Minute
+
+var providerNameRX = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// loadOAuthProviders discovers the providers listed in OIDC_PROVIDERS ("google,okta") , each one
+// configured by OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET. Callbacks
+// are at OIDC_REDIRECT_BASE_URL/user/oauth/<name>/callback.
+func loadOAuthProviders(baseURL string) (map[string]oauth.Provider, error) {
+	providers := map[string]oauth.Provider{}
+
+	names := os.Getenv("OIDC_PROVIDERS")
+	if names == "" {
+		return providers, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if !providerNameRX.MatchString(name) {
+			return nil, fmt.Errorf("oidc provider %q: names are lower case letters, digits and dashes", name)
+		}
+
+		env := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := oauth.Config{
+			Issuer:       os.Getenv(env + "ISSUER"),
+			ClientID:     os.Getenv(env + "CLIENT_ID"),
+			ClientSecret: os.Getenv(env + "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimSuffix(baseURL, "/") + "/user/oauth/" + name + "/callback",
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %s: %sISSUER and %sCLIENT_ID must be set", name, env, env)
+		}
+
+		provider, err := oauth.Discover(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("oidc provider %s: %w", name, err)
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// oauthProvider returns the provider named in the route , writing a 404 when there is none.
+func (app *application) oauthProvider(w http.ResponseWriter, r *http.Request) (string, oauth.Provider, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	provider, ok := app.oauth[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return "", nil, false
+	}
+	return name, provider, true
+}
+
+// OAuthStart redirects to the provider's sign in page.
+func (app *application) OAuthStart(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := app.oauthProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := oauth.RandomString()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	nonce, err := oauth.RandomString()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	s := &data.OAuthState{
+		State:        state,
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: oauth.GenerateVerifier(),
+		ExpiresAt:    time.Now().Add(oauthStateExpiry),
+	}
+	if err = app.models.Identities.SaveState(r.Context(), s); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(s.State, s.Nonce, s.CodeVerifier), http.StatusFound)
+}
+
+// OAuthCallback finishes the sign in , it logs in the user linked to the identity, links it to the
+// account with the same (verified) email or creates a new account.
+func (app *application) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := app.oauthProvider(w, r)
+	if !ok {
+		return
+	}
+
+	qs := r.URL.Query()
+	if e := qs.Get("error"); e != "" {
+		app.audit(r, data.AuditLoginFailed, 0, map[string]interface{}{"provider": name, "reason": e})
+		app.errorResponse(w, r, http.StatusUnauthorized, "sign in at "+name+" failed: "+e)
+		return
+	}
+
+	s, err := app.models.Identities.TakeState(r.Context(), qs.Get("state"))
+	if err != nil || s.Provider != name {
+		switch {
+		case err == nil || errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("unknown or expired sign in , start again"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	identity, err := provider.Exchange(ctx, qs.Get("code"), s.CodeVerifier, s.Nonce)
+	if err != nil {
+		app.logError(r, err)
+		app.audit(r, data.AuditLoginFailed, 0, map[string]interface{}{"provider": name, "reason": "code exchange failed"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// the account created when nobody has this email yet , it has no usable password.
+	password, err := oauth.RandomString()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	newUser := &data.User{
+		Email:     identity.Email,
+		Role:      "user",
+		FirstName: truncate(validator.SanitizeString(identity.FirstName), 100),
+		LastName:  truncate(validator.SanitizeString(identity.LastName), 100),
+	}
+	if err = newUser.Password.Set(password); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	login := data.ExternalLogin{
+		Provider:      name,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+	}
+	userID, _, err := app.models.Identities.Resolve(r.Context(), login, newUser, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEmailNotVerified):
+			app.audit(r, data.AuditLoginFailed, 0, map[string]interface{}{"provider": name, "reason": "email not verified"})
+			app.errorResponse(w, r, http.StatusForbidden, "your "+name+" account has no verified email")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.firstFactorPassed(w, r, user)
+}
+
+// truncate cuts s to at most n characters.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
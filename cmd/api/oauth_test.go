@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	auth "interviewTask/internal/authentication"
+	"interviewTask/internal/data"
+	"interviewTask/internal/jsonlog"
+	"interviewTask/internal/oauth"
+	"interviewTask/internal/oauth/oauthtest"
+	"interviewTask/internal/testdb"
+)
+
+// oauthTest is the API with the "mock" and "other" providers , both signing in at the same mock issuer.
+type oauthTest struct {
+	routes http.Handler
+	models data.Models
+	issuer *oauthtest.Issuer
+}
+
+func newOAuthTest(t *testing.T) *oauthTest {
+	db := testdb.New(t)
+	useTestJWTKey(t)
+
+	issuer := oauthtest.NewIssuer(t)
+	providers := map[string]oauth.Provider{}
+	for _, name := range []string{"mock", "other"} {
+		provider, err := oauth.Discover(context.Background(), oauth.Config{
+			Issuer:       issuer.URL,
+			ClientID:     issuer.ClientID,
+			ClientSecret: issuer.ClientSecret,
+			RedirectURL:  "http://localhost:4000/user/oauth/" + name + "/callback",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers[name] = provider
+	}
+
+	app := &application{
+		logger:  jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models:  data.NewModel(db),
+		oauth:   providers,
+		metrics: newMetrics(db),
+	}
+	return &oauthTest{routes: app.routes(), models: app.models, issuer: issuer}
+}
+
+// useTestJWTKey signs the tokens of the test with a fresh Ed25519 key.
+func useTestJWTKey(t *testing.T) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "jwt.pem")
+	if err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeys(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.UseKeys(keys)
+}
+
+func (ot *oauthTest) get(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	ot.routes.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	return rr
+}
+
+// start begins a sign in at provider and returns the callback the issuer sends the browser back to.
+func (ot *oauthTest) start(t *testing.T, provider string) *url.URL {
+	t.Helper()
+
+	rr := ot.get(t, "/user/oauth/"+provider+"/start")
+	if rr.Code != http.StatusFound {
+		t.Fatalf("start: got %d , want %d: %s", rr.Code, http.StatusFound, rr.Body)
+	}
+	authURL, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qs := authURL.Query()
+	if qs.Get("state") == "" || qs.Get("nonce") == "" || qs.Get("code_challenge_method") != "S256" {
+		t.Fatalf("start: redirected without state, nonce or PKCE: %s", authURL)
+	}
+
+	callback, err := ot.issuer.SignIn(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback
+}
+
+// signIn runs the whole flow at provider and returns the callback response.
+func (ot *oauthTest) signIn(t *testing.T, provider string) *httptest.ResponseRecorder {
+	t.Helper()
+	return ot.get(t, ot.start(t, provider).RequestURI())
+}
+
+// loggedIn decodes the response of a successful sign in.
+func loggedIn(t *testing.T, rr *httptest.ResponseRecorder) data.User {
+	t.Helper()
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d , want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var body struct {
+		User  data.User `json:"user"`
+		Token string    `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.Authenticate(body.Token)
+	if err != nil {
+		t.Fatalf("the token does not authenticate: %v", err)
+	}
+	if id, _ := claims.UserID(); id != body.User.ID {
+		t.Fatalf("token of user %d for user %d", id, body.User.ID)
+	}
+	return body.User
+}
+
+func (ot *oauthTest) insertUser(t *testing.T, email string) *data.User {
+	t.Helper()
+
+	user := &data.User{Email: email, Role: "user", FirstName: "Existing"}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ot.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func (ot *oauthTest) identities(t *testing.T, userID int64) int {
+	t.Helper()
+
+	var n int
+	err := ot.models.Users.DB.QueryRow(`SELECT count(*) FROM user_identities WHERE user_id = $1`, userID).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestOAuthSignIn(t *testing.T) {
+	ot := newOAuthTest(t)
+
+	var created data.User
+	t.Run("a new email creates an account", func(t *testing.T) {
+		ot.issuer.SetUser(oauthtest.User{Subject: "new-1", Email: "new@example.com", EmailVerified: true, GivenName: "Nina"})
+
+		created = loggedIn(t, ot.signIn(t, "mock"))
+		if created.Email != "new@example.com" || created.FirstName != "Nina" {
+			t.Fatalf("created %+v", created)
+		}
+		if n := ot.identities(t, created.ID); n != 1 {
+			t.Fatalf("%d identities linked , want 1", n)
+		}
+	})
+
+	t.Run("a linked identity signs in without a verified email", func(t *testing.T) {
+		ot.issuer.SetUser(oauthtest.User{Subject: "new-1", Email: "renamed@example.com", EmailVerified: false})
+
+		user := loggedIn(t, ot.signIn(t, "mock"))
+		if user.ID != created.ID {
+			t.Fatalf("signed in as user %d , want %d", user.ID, created.ID)
+		}
+	})
+
+	t.Run("a verified email links the existing account", func(t *testing.T) {
+		existing := ot.insertUser(t, "existing@example.com")
+		ot.issuer.SetUser(oauthtest.User{Subject: "existing-1", Email: "Existing@Example.com", EmailVerified: "true"})
+
+		user := loggedIn(t, ot.signIn(t, "mock"))
+		if user.ID != existing.ID {
+			t.Fatalf("signed in as user %d , want the existing user %d", user.ID, existing.ID)
+		}
+		if n := ot.identities(t, existing.ID); n != 1 {
+			t.Fatalf("%d identities linked , want 1", n)
+		}
+	})
+
+	t.Run("an unverified email does not take over an account", func(t *testing.T) {
+		victim := ot.insertUser(t, "victim@example.com")
+		ot.issuer.SetUser(oauthtest.User{Subject: "attacker-1", Email: "victim@example.com", EmailVerified: false})
+
+		rr := ot.signIn(t, "mock")
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("got %d , want %d: %s", rr.Code, http.StatusForbidden, rr.Body)
+		}
+		if n := ot.identities(t, victim.ID); n != 0 {
+			t.Fatalf("%d identities linked , want none", n)
+		}
+	})
+}
+
+func TestOAuthCallbackRejects(t *testing.T) {
+	ot := newOAuthTest(t)
+	ot.issuer.SetUser(oauthtest.User{Subject: "s-1", Email: "user@example.com", EmailVerified: true})
+
+	tests := []struct {
+		name     string
+		callback func(t *testing.T) string
+		status   int
+	}{
+		{
+			name:     "unknown state",
+			callback: func(t *testing.T) string { return "/user/oauth/mock/callback?code=code&state=forged" },
+			status:   http.StatusBadRequest,
+		},
+		{
+			name: "state used twice",
+			callback: func(t *testing.T) string {
+				callback := ot.start(t, "mock").RequestURI()
+				loggedIn(t, ot.get(t, callback))
+				return callback
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "state of another provider",
+			callback: func(t *testing.T) string {
+				return "/user/oauth/mock/callback?" + ot.start(t, "other").RawQuery
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "code with the state of another sign in",
+			callback: func(t *testing.T) string {
+				first, second := ot.start(t, "mock"), ot.start(t, "mock")
+				qs := first.Query()
+				qs.Set("state", second.Query().Get("state"))
+				return "/user/oauth/mock/callback?" + qs.Encode()
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "nonce mismatch",
+			callback: func(t *testing.T) string {
+				ot.issuer.SetNonce("replayed-nonce")
+				t.Cleanup(func() { ot.issuer.SetNonce("") })
+				return ot.start(t, "mock").RequestURI()
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:     "sign in refused at the provider",
+			callback: func(t *testing.T) string { return "/user/oauth/mock/callback?error=access_denied&state=whatever" },
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown provider",
+			callback: func(t *testing.T) string { return "/user/oauth/nope/callback?code=code&state=state" },
+			status:   http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := ot.get(t, tt.callback(t))
+			if rr.Code != tt.status {
+				t.Fatalf("got %d , want %d: %s", rr.Code, tt.status, rr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+// admin order management handlers.
+
+func (app *application) ListOrders(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read the optional filters from the query string.
+	var filters data.OrderFilters
+	filters.Status = app.readString(qs, "status", "")
+	filters.UserID = int64(app.readInt(qs, "user_id", 0, v))
+	filters.From = app.readDate(qs, "from", v)
+	filters.To = app.readDate(qs, "to", v)
+	filters.MinAmount = app.readFloat(qs, "min_amount", 0, v)
+	filters.MaxAmount = app.readFloat(qs, "max_amount", 0, v)
+	filters.PaymentID = app.readString(qs, "payment_id", "")
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"id", "total_amount", "created_at", "-id", "-total_amount", "-created_at"}
+
+	if data.ValidateOrderFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.Orders.GetAll(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+}
+
+func (app *application) ShowOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Orders.GetDetails(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+func (app *application) FulfillOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Orders.MarkFulfilled(r.Context(), id, app.actor(r))
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+func (app *application) UpdateOrderTracking(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		TrackingNumber string `json:"tracking_number"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	input.TrackingNumber = validator.SanitizeString(input.TrackingNumber)
+
+	v := validator.New()
+	if data.ValidateTrackingNumber(v, input.TrackingNumber); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.models.Orders.SetTrackingNumber(r.Context(), id, input.TrackingNumber, app.actor(r))
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+func (app *application) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Orders.Get(r.Context(), id)
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+	if !validator.In(order.Status, data.CancellableStatuses...) {
+		app.orderStatusConflictResponse(w, r)
+		return
+	}
+
+	// Release the payment first , the order is only cancelled once the money is back.
+	err = app.cancelStripePayment(r.Context(), order.StripePaymentID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the money is back already , a client hanging up must not leave the order open.
+	order, err = app.models.Orders.Cancel(context.WithoutCancel(r.Context()), id, app.actor(r))
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+// customer order handlers.
+
+func (app *application) ShowUserOrder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Orders.GetDetails(r.Context(), id)
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+
+	// someone else's order is reported as missing , don't reveal that the id exists.
+	if order.UserID != userID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+func (app *application) CancelUserOrder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.models.Orders.Get(r.Context(), id)
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+	if order.UserID != userID {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if !validator.In(order.Status, data.CancellableStatuses...) {
+		app.orderStatusConflictResponse(w, r)
+		return
+	}
+
+	err = app.cancelStripePayment(r.Context(), order.StripePaymentID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	order, err = app.models.Orders.CancelForUser(context.WithoutCancel(r.Context()), id, app.actor(r))
+	if err != nil {
+		app.orderActionError(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+// orderActionError maps the errors returned by the order status actions to a response.
+func (app *application) orderActionError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrInvalidOrderStatus):
+		app.orderStatusConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
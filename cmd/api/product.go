@@ -9,9 +9,9 @@ import (
 	"time"
 )
 
-func (app application) ListProducts(w http.ResponseWriter, r *http.Request) {
+func (app *application) ListProducts(w http.ResponseWriter, r *http.Request) {
 
-	products, err := app.models.Product.GetAll()
+	products, err := app.models.Product.GetAll(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -21,18 +21,18 @@ func (app application) ListProducts(w http.ResponseWriter, r *http.Request) {
 }
 
 // admin handleres  ,, neet to refine some error handling later .
-func (app application) CreateProduct(w http.ResponseWriter, r *http.Request) {
+func (app *application) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the authenticated userId from the request context.
 	userId, ok := r.Context().Value(userContextKey).(int64)
 	if !ok {
-		app.logger.PrintInfo(fmt.Sprintf("userId is : %s ", userId), nil)
+		app.logger.PrintInfo(fmt.Sprintf("userId is : %d ", userId), nil)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	user, err := app.models.Users.GetByID(userId)
+	user, err := app.models.Users.GetByID(r.Context(), userId)
 	if err != nil {
-		app.logger.PrintInfo(fmt.Sprintf("user in create product %s ", user), nil)
+		app.logger.PrintInfo(fmt.Sprintf("user in create product %v ", user), nil)
 		app.serverErrorResponse(w, r, err)
 	}
 	// Check if the authenticated userId has admin privileges.
@@ -43,10 +43,12 @@ func (app application) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	// Define a struct to capture the expected json .
 	var input struct {
+		SKU            string  `json:"sku"`
 		Name           string  `json:"name"`
 		Description    string  `json:"description"`
 		Price          float64 `json:"price"`
 		InventoryCount int     `json:"Quantity"`
+		LowStockLevel  int     `json:"low_stock_threshold"`
 	}
 
 	// Read and decode the json request body.
@@ -58,10 +60,13 @@ func (app application) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	// Create a new product instance.
 	product := &data.Product{
+		SKU:            input.SKU,
 		Name:           input.Name,
 		Description:    input.Description,
 		Price:          input.Price,
 		InventoryCount: input.InventoryCount,
+
+		LowStockThreshold: input.LowStockLevel,
 	}
 
 	// Validate the product.
@@ -73,9 +78,14 @@ func (app application) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert the product into the database.
-	err = app.models.Product.Create(product)
+	err = app.models.Product.Create(r.Context(), product, app.actor(r))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		if errors.Is(err, data.ErrDuplicateSKU) {
+			v.AddError("sku", "a product with this sku already exists")
+			app.validationErrorResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -83,7 +93,7 @@ func (app application) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	app.writeJson(w, http.StatusCreated, envelope{"product": product}, nil)
 }
 
-func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+func (app *application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the product ID from the URL.
 	id, err := app.readIDparam(r)
 	if err != nil {
@@ -99,7 +109,7 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the user from the database to check their role.
-	user, err := app.models.Users.GetByID(userID)
+	user, err := app.models.Users.GetByID(r.Context(), userID)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.invalidCredentialsResponse(w, r)
@@ -117,10 +127,12 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 
 	// Define a struct to capture the expected JSON input for updates.
 	var input struct {
+		SKU            *string  `json:"sku"`
 		Name           *string  `json:"name"`
 		Description    *string  `json:"description"`
 		Price          *float64 `json:"price"`
-		InventoryCount *int     `json:"Quantity"`
+		InventoryCount *int     `json:"Quantity"` // recorded as a manual adjustment in the stock ledger
+		LowStockLevel  *int     `json:"low_stock_threshold"`
 	}
 
 	// Read and decode the JSON request body.
@@ -131,7 +143,7 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the current product from the database.
-	product, err := app.models.Product.GetByID(id)
+	product, err := app.models.Product.GetByID(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -142,6 +154,9 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// assign new product values
+	if input.SKU != nil {
+		product.SKU = *input.SKU
+	}
 	if input.Name != nil {
 		product.Name = *input.Name
 	}
@@ -154,6 +169,9 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	if input.InventoryCount != nil {
 		product.InventoryCount = *input.InventoryCount
 	}
+	if input.LowStockLevel != nil {
+		product.LowStockThreshold = *input.LowStockLevel
+	}
 
 	// Validate and update the product fields if provided.
 	v := validator.New()
@@ -164,9 +182,18 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update the product in the database.
-	err = app.models.Product.Update(product)
+	// the stock is only touched when a quantity was sent , the model takes the delta from the locked row.
+	err = app.models.Product.Update(r.Context(), product, input.InventoryCount, app.actor(r))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSKU):
+			v.AddError("sku", "a product with this sku already exists")
+			app.validationErrorResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -174,7 +201,7 @@ func (app application) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	app.writeJson(w, http.StatusOK, envelope{"product": product}, nil)
 }
 
-func (app application) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+func (app *application) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the product ID from the URL.
 	id, err := app.readIDparam(r)
 	if err != nil {
@@ -190,7 +217,7 @@ func (app application) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the user from the database to check their role.
-	user, err := app.models.Users.GetByID(userID)
+	user, err := app.models.Users.GetByID(r.Context(), userID)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.invalidCredentialsResponse(w, r)
@@ -207,7 +234,7 @@ func (app application) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the data layer to delete the product.
-	err = app.models.Product.Delete(id)
+	err = app.models.Product.Delete(r.Context(), id, app.actor(r))
 	if err != nil {
 		// If the product wasn't found, return a not found response.
 		if errors.Is(err, data.ErrRecordNotFound) {
@@ -222,7 +249,7 @@ func (app application) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	app.writeJson(w, http.StatusOK, envelope{"message": "product deleted successfully"}, nil)
 }
 
-func (app application) SalesFiltering(w http.ResponseWriter, r *http.Request) {
+func (app *application) SalesFiltering(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the authenticated user ID from the request context.
 	userID, ok := r.Context().Value(userContextKey).(int64)
 	if !ok {
@@ -231,7 +258,7 @@ func (app application) SalesFiltering(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the user from the database to check their role.
-	user, err := app.models.Users.GetByID(userID)
+	user, err := app.models.Users.GetByID(r.Context(), userID)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.invalidCredentialsResponse(w, r)
@@ -248,36 +275,38 @@ func (app application) SalesFiltering(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Read query parameters.
-	// Expecting "from" and "to" dates in "2006-01-02" format.
-	q := r.URL.Query()
-	fromStr := q.Get("from")
-	toStr := q.Get("to")
-	username := q.Get("username") // optional filter
+	// Expecting "from" and "to" calendar days in "2006-01-02" format , both included ,
+	// interpreted in the "tz" IANA timezone (UTC by default).
+	v := validator.New()
+	qs := r.URL.Query()
 
-	if fromStr == "" || toStr == "" {
-		app.badRequestResponse(w, r, err)
-		return
+	location, err := time.LoadLocation(app.readString(qs, "tz", "UTC"))
+	if err != nil {
+		v.AddError("tz", "must be a valid IANA timezone")
+		location = time.UTC
 	}
 
-	fromTime, err := time.Parse("2006-01-02", fromStr)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+	filters := data.SalesFilters{
+		Statuses:    app.readCSV(qs, "status", data.RevenueStatuses),
+		IncludeZero: app.readString(qs, "include_zero", "false") == "true",
+		Username:    app.readString(qs, "username", ""), // optional filter
+		UserID:      int64(app.readInt(qs, "user_id", 0, v)),
+		Email:       app.readString(qs, "email", ""),
 	}
+	filters.From, filters.To = app.readDayRange(qs, location, v)
 
-	toTime, err := time.Parse("2006-01-02", toStr)
-	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, "invalid 'to' date format; expected YYYY-MM-DD")
+	if data.ValidateSalesFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
 		return
 	}
 
 	// Call the SalesFiltering method in the Product model.
-	sales, err := app.models.Product.SalesFiltering(fromTime, toTime, username)
+	sales, err := app.models.Product.SalesFiltering(r.Context(), filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Return the sales data as JSON.
-	app.writeJson(w, http.StatusOK, envelope{"sales": sales}, nil)
+	app.writeJson(w, http.StatusOK, envelope{"sales": sales, "from": filters.From, "to": filters.To}, nil)
 }
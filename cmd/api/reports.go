@@ -0,0 +1,120 @@
+package main
+
+import (
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// admin sales reports , every report accepts format=csv to download it instead of JSON.
+
+// readReportFilters reads the filters shared by all reports , failures are recorded in the validator.
+func (app *application) readReportFilters(r *http.Request, v *validator.Validator) (data.ReportFilters, string) {
+	qs := r.URL.Query()
+
+	var filters data.ReportFilters
+	filters.From = app.readDate(qs, "from", v)
+	filters.To = app.readDate(qs, "to", v)
+	filters.Interval = app.readString(qs, "interval", "day")
+	filters.Statuses = app.readCSV(qs, "status", data.RevenueStatuses)
+	filters.UserID = int64(app.readInt(qs, "user_id", 0, v))
+	filters.Email = app.readString(qs, "email", "")
+	filters.Limit = app.readInt(qs, "limit", 10, v)
+
+	format := app.readString(qs, "format", "json")
+	v.Check(validator.In(format, "json", "csv"), "format", "must be json or csv")
+
+	data.ValidateReportFilters(v, filters)
+	return filters, format
+}
+
+func formatAmount(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+func (app *application) RevenueReport(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters, format := app.readReportFilters(r, v)
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	points, err := app.models.Reports.Revenue(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, 0, len(points))
+		for _, p := range points {
+			rows = append(rows, []string{p.Period.Format(time.DateOnly), strconv.Itoa(p.Orders), strconv.Itoa(p.Units), formatAmount(p.Revenue)})
+		}
+		err = app.writeCSV(w, "revenue.csv", []string{"period", "orders", "units", "revenue"}, rows)
+		if err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"interval": filters.Interval, "revenue": points}, nil)
+}
+
+func (app *application) TopCustomersReport(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters, format := app.readReportFilters(r, v)
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	customers, err := app.models.Reports.TopCustomers(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, 0, len(customers))
+		for _, c := range customers {
+			rows = append(rows, []string{strconv.FormatInt(c.UserID, 10), c.Email, c.FirstName, c.LastName,
+				strconv.Itoa(c.Orders), formatAmount(c.Revenue), formatAmount(c.AverageOrderValue)})
+		}
+		header := []string{"user_id", "email", "first_name", "last_name", "orders", "revenue", "average_order_value"}
+		if err = app.writeCSV(w, "top-customers.csv", header, rows); err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"customers": customers}, nil)
+}
+
+func (app *application) SummaryReport(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters, format := app.readReportFilters(r, v)
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	summary, err := app.models.Reports.Summary(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		row := []string{strconv.Itoa(summary.Orders), strconv.Itoa(summary.Units), formatAmount(summary.Revenue), formatAmount(summary.AverageOrderValue)}
+		err = app.writeCSV(w, "summary.csv", []string{"orders", "units", "revenue", "average_order_value"}, [][]string{row})
+		if err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"summary": summary}, nil)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+func (app *application) ListProductReviews(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "rating", "-created_at", "-rating"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForProduct(r.Context(), productID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+}
+
+func (app *application) CreateProductReview(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Rating int    `json:"rating"`
+		Body   string `json:"body"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	review := &data.Review{
+		ProductID: productID,
+		UserID:    userID,
+		Rating:    input.Rating,
+		Body:      validator.SanitizeString(input.Body),
+	}
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	// only verified purchasers can review a product.
+	purchased, err := app.models.Reviews.HasPurchased(r.Context(), userID, productID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !purchased {
+		app.notVerifiedPurchaserResponse(w, r)
+		return
+	}
+
+	err = app.models.Reviews.Upsert(r.Context(), review)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusCreated, envelope{"review": review}, nil)
+}
+
+// admin moderation handlers.
+
+func (app *application) ListReviews(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	productID := int64(app.readInt(qs, "product_id", 0, v))
+	status := app.readString(qs, "status", "")
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "rating", "-created_at", "-rating"}
+
+	if status != "" {
+		v.Check(validator.In(status, data.ReviewStatusPublished, data.ReviewStatusHidden), "status", "must be published or hidden")
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAll(r.Context(), productID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+}
+
+func (app *application) ModerateReview(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Status, data.ReviewStatusPublished, data.ReviewStatusHidden), "status", "must be published or hidden")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	review, err := app.models.Reviews.SetStatus(r.Context(), id, input.Status, app.actor(r))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"review": review}, nil)
+}
+
+func (app *application) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Reviews.Delete(r.Context(), id, app.actor(r))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"message": "review deleted successfully"}, nil)
+}
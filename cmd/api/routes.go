@@ -8,38 +8,107 @@ import (
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	// every route is registered with its metrics and span , requests matching no route are counted together.
+	router := instrumentedRouter{Router: httprouter.New(), metrics: app.metrics}
 
 	// Override default handlers.
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
+	router.NotFound = router.instrument("unmatched", http.HandlerFunc(app.notFoundResponse))
+	router.MethodNotAllowed = router.instrument("unmatched", http.HandlerFunc(app.methodNotAllowed))
 
 	// Create two chains:
-	// All routes need authentication.
-	authChain := alice.New(app.AuthMiddleware)
-	// Admin routes need both authentication and an admin role check.
-	adminChain := alice.New(app.AuthMiddleware, app.RequireRole("admin"))
+	// All routes need authentication , POST requests also honour the Idempotency-Key header.
+	// API keys are for the admin routes only.
+	authChain := alice.New(app.AuthMiddleware, app.RequireScope(""), app.Idempotency)
+	// Admin routes need authentication , an admin role and a token issued after a second factor.
+	// API keys need the scope of the route instead , "" keeps them out.
+	adminChain := func(scope string) alice.Chain {
+		return alice.New(app.AuthMiddleware, app.RequireRole("admin"), app.RequireMFA, app.RequireScope(scope), app.Idempotency)
+	}
+	// an import file may be much larger than a JSON body , the Idempotency-Key fingerprint reads all of it.
+	importChain := alice.New(app.AuthMiddleware, app.RequireRole("admin"), app.RequireMFA, app.RequireScope("products:write"),
+		app.IdempotencyLimit(maxImportBytes))
 
 	//  public routes
 	router.HandlerFunc(http.MethodPost, "/user/signup", app.SignUpUser)
 	router.HandlerFunc(http.MethodPost, "/user/login", app.LoginUser)
+	router.HandlerFunc(http.MethodPost, "/user/login/mfa", app.LoginUserMFA)
+	router.HandlerFunc(http.MethodGet, "/user/oauth/:provider/start", app.OAuthStart)
+	router.HandlerFunc(http.MethodGet, "/user/oauth/:provider/callback", app.OAuthCallback)
 	router.HandlerFunc(http.MethodGet, "/user/products", app.ListProducts)
+	router.HandlerFunc(http.MethodGet, "/user/products/:id/reviews", app.ListProductReviews)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.JWKS)
 
 	//  stripe callback
 	router.HandlerFunc(http.MethodPost, "/stripe/webhook", app.stripeWebhookHandler)
 
 	// require authentication.
+	router.Handler(http.MethodPost, "/user/mfa/totp", authChain.Then(http.HandlerFunc(app.EnrollTOTP)))
+	router.Handler(http.MethodPost, "/user/mfa/totp/confirm", authChain.Then(http.HandlerFunc(app.ConfirmTOTP)))
+	router.Handler(http.MethodDelete, "/user/mfa/totp", authChain.Then(http.HandlerFunc(app.DisableTOTP)))
+	router.Handler(http.MethodPost, "/user/mfa/recovery-codes", authChain.Then(http.HandlerFunc(app.RegenerateRecoveryCodes)))
+
 	router.Handler(http.MethodPost, "/user/credit-card", authChain.Then(http.HandlerFunc(app.AddCreditCard)))
 	router.Handler(http.MethodDelete, "/user/credit-card", authChain.Then(http.HandlerFunc(app.DeleteCreditCard)))
+	router.Handler(http.MethodGet, "/user/credit-cards", authChain.Then(http.HandlerFunc(app.ListCreditCards)))
+	router.Handler(http.MethodPatch, "/user/credit-cards/:id", authChain.Then(http.HandlerFunc(app.UpdateCreditCard)))
+
+	router.Handler(http.MethodPost, "/user/products/:id/reviews", authChain.Then(http.HandlerFunc(app.CreateProductReview)))
+
+	router.Handler(http.MethodGet, "/user/wishlist", authChain.Then(http.HandlerFunc(app.ListWishlist)))
+	router.Handler(http.MethodPost, "/user/wishlist", authChain.Then(http.HandlerFunc(app.AddToWishlist)))
+	router.Handler(http.MethodDelete, "/user/wishlist/:id", authChain.Then(http.HandlerFunc(app.RemoveFromWishlist)))
+
+	router.Handler(http.MethodGet, "/user/notifications", authChain.Then(http.HandlerFunc(app.ListNotifications)))
+	router.Handler(http.MethodPatch, "/user/notifications/:id", authChain.Then(http.HandlerFunc(app.UpdateNotification)))
+	router.Handler(http.MethodPost, "/user/notifications/read-all", authChain.Then(http.HandlerFunc(app.MarkAllNotificationsRead)))
+	router.Handler(http.MethodGet, "/user/notification-preferences", authChain.Then(http.HandlerFunc(app.GetNotificationPreferences)))
+	router.Handler(http.MethodPut, "/user/notification-preferences", authChain.Then(http.HandlerFunc(app.UpdateNotificationPreferences)))
 
 	router.Handler(http.MethodPost, "/user/buy", authChain.Then(http.HandlerFunc(app.BuyProducts)))
 	router.Handler(http.MethodGet, "/user/purchase-history", authChain.Then(http.HandlerFunc(app.GetPurchaseHistory)))
+	router.Handler(http.MethodGet, "/user/orders/:id", authChain.Then(http.HandlerFunc(app.ShowUserOrder)))
+	router.Handler(http.MethodPost, "/user/orders/:id/cancel", authChain.Then(http.HandlerFunc(app.CancelUserOrder)))
 
 	// Admin endpoints: Require admin privileges.
-	router.Handler(http.MethodPost, "/admin/products", adminChain.Then(http.HandlerFunc(app.CreateProduct)))
-	router.Handler(http.MethodPut, "/admin/products/:id", adminChain.Then(http.HandlerFunc(app.UpdateProduct)))
-	router.Handler(http.MethodDelete, "/admin/products/:id", adminChain.Then(http.HandlerFunc(app.DeleteProduct)))
-	router.Handler(http.MethodGet, "/admin/sales", adminChain.Then(http.HandlerFunc(app.SalesFiltering)))
+	router.Handler(http.MethodPost, "/admin/products", adminChain("products:write").Then(http.HandlerFunc(app.CreateProduct)))
+	router.Handler(http.MethodPost, "/admin/product-imports", importChain.Then(http.HandlerFunc(app.ImportProducts)))
+	router.Handler(http.MethodGet, "/admin/product-exports", adminChain("products:read").Then(http.HandlerFunc(app.ExportProducts)))
+	router.Handler(http.MethodPut, "/admin/products/:id", adminChain("products:write").Then(http.HandlerFunc(app.UpdateProduct)))
+	router.Handler(http.MethodDelete, "/admin/products/:id", adminChain("products:write").Then(http.HandlerFunc(app.DeleteProduct)))
+	router.Handler(http.MethodPost, "/admin/products/:id/stock-adjustments", adminChain("inventory:write").Then(http.HandlerFunc(app.CreateStockAdjustment)))
+	router.Handler(http.MethodGet, "/admin/products/:id/stock-movements", adminChain("inventory:read").Then(http.HandlerFunc(app.ListStockMovements)))
+	router.Handler(http.MethodGet, "/admin/sales", adminChain("reports:read").Then(http.HandlerFunc(app.SalesFiltering)))
+	router.Handler(http.MethodGet, "/admin/reports/revenue", adminChain("reports:read").Then(http.HandlerFunc(app.RevenueReport)))
+	router.Handler(http.MethodGet, "/admin/reports/top-customers", adminChain("reports:read").Then(http.HandlerFunc(app.TopCustomersReport)))
+	router.Handler(http.MethodGet, "/admin/reports/summary", adminChain("reports:read").Then(http.HandlerFunc(app.SummaryReport)))
+
+	router.Handler(http.MethodGet, "/admin/orders", adminChain("orders:read").Then(http.HandlerFunc(app.ListOrders)))
+	router.Handler(http.MethodGet, "/admin/orders/:id", adminChain("orders:read").Then(http.HandlerFunc(app.ShowOrder)))
+	router.Handler(http.MethodPost, "/admin/orders/:id/fulfil", adminChain("orders:write").Then(http.HandlerFunc(app.FulfillOrder)))
+	router.Handler(http.MethodPut, "/admin/orders/:id/tracking", adminChain("orders:write").Then(http.HandlerFunc(app.UpdateOrderTracking)))
+	router.Handler(http.MethodPost, "/admin/orders/:id/cancel", adminChain("orders:write").Then(http.HandlerFunc(app.CancelOrder)))
+
+	router.Handler(http.MethodGet, "/admin/reviews", adminChain("reviews:read").Then(http.HandlerFunc(app.ListReviews)))
+	router.Handler(http.MethodPatch, "/admin/reviews/:id", adminChain("reviews:write").Then(http.HandlerFunc(app.ModerateReview)))
+	router.Handler(http.MethodDelete, "/admin/reviews/:id", adminChain("reviews:write").Then(http.HandlerFunc(app.DeleteReview)))
+
+	router.Handler(http.MethodGet, "/admin/audit", adminChain("audit:read").Then(http.HandlerFunc(app.ListAuditEvents)))
+
+	router.Handler(http.MethodPost, "/admin/webhooks", adminChain("webhooks:write").Then(http.HandlerFunc(app.CreateWebhook)))
+	router.Handler(http.MethodGet, "/admin/webhooks", adminChain("webhooks:read").Then(http.HandlerFunc(app.ListWebhooks)))
+	router.Handler(http.MethodPatch, "/admin/webhooks/:id", adminChain("webhooks:write").Then(http.HandlerFunc(app.UpdateWebhook)))
+	router.Handler(http.MethodDelete, "/admin/webhooks/:id", adminChain("webhooks:write").Then(http.HandlerFunc(app.DeleteWebhook)))
+	router.Handler(http.MethodGet, "/admin/webhooks/:id/deliveries", adminChain("webhooks:read").Then(http.HandlerFunc(app.ListWebhookDeliveries)))
+	router.Handler(http.MethodGet, "/admin/webhook-deliveries/:id", adminChain("webhooks:read").Then(http.HandlerFunc(app.ShowWebhookDelivery)))
+	router.Handler(http.MethodPost, "/admin/webhook-deliveries/:id/redeliver", adminChain("webhooks:write").Then(http.HandlerFunc(app.RedeliverWebhook)))
+
+	router.Handler(http.MethodGet, "/admin/jobs", adminChain("").Then(http.HandlerFunc(app.ListJobs)))
+	router.Handler(http.MethodPost, "/admin/jobs/:id/retry", adminChain("").Then(http.HandlerFunc(app.RetryJob)))
+
+	// managing API keys needs a person.
+	router.Handler(http.MethodPost, "/admin/api-keys", adminChain("").Then(http.HandlerFunc(app.CreateAPIKey)))
+	router.Handler(http.MethodGet, "/admin/api-keys", adminChain("").Then(http.HandlerFunc(app.ListAPIKeys)))
+	router.Handler(http.MethodDelete, "/admin/api-keys/:id", adminChain("").Then(http.HandlerFunc(app.RevokeAPIKey)))
 
 	return router
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"interviewTask/internal/jsonlog"
+)
+
+// TestRoutes builds the router without a database , httprouter panics on conflicting routes. The requests
+// are turned away before any handler needs the database.
+func TestRoutes(t *testing.T) {
+	app := &application{
+		logger:  jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		metrics: newMetrics(nil),
+	}
+	routes := app.routes()
+
+	tests := []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodPost, "/admin/product-imports", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/product-exports", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/products/1/stock-adjustments", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/products/1/stock-movements", http.StatusUnauthorized},
+		{http.MethodPut, "/admin/products/1", http.StatusUnauthorized},
+		{http.MethodGet, "/nope", http.StatusNotFound},
+		{http.MethodPatch, "/user/buy", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, nil))
+			if rr.Code != tt.status {
+				t.Fatalf("got %d , want %d: %s", rr.Code, tt.status, rr.Body)
+			}
+		})
+	}
+}
@@ -24,6 +24,32 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// /metrics has its own port so it can stay off the public network.
+	var metricsSrv *http.Server
+	if app.config.metrics.port != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", app.metrics.handler())
+		metricsSrv = &http.Server{
+			Addr:         fmt.Sprintf(":%d", app.config.metrics.port),
+			Handler:      mux,
+			ErrorLog:     log.New(app.logger, "", 0),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			app.logger.PrintInfo("starting metrics server", map[string]string{"addr": metricsSrv.Addr})
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": metricsSrv.Addr})
+			}
+		}()
+	}
+
+	// the job queue runs until the server shuts down , then the jobs being run are finished.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	app.background(func() { app.jobs.Run(jobsCtx) })
+
 	shutDownError := make(chan error)
 	go func() {
 		shutDown := make(chan os.Signal, 1)
@@ -40,11 +66,16 @@ func (app *application) serve() error {
 		if err != nil {
 			shutDownError <- err
 		}
+		if metricsSrv != nil {
+			metricsSrv.Shutdown(ctx)
+		}
 
 		app.logger.PrintInfo("wait .... completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
 
+		stopJobs()
+		app.wg.Wait()
 		shutDownError <- nil
 
 	}()
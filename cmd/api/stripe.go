@@ -1,49 +1,257 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/customer"
 	"github.com/stripe/stripe-go/v72/paymentintent"
+	"github.com/stripe/stripe-go/v72/paymentmethod"
+	"github.com/stripe/stripe-go/v72/refund"
 	"github.com/stripe/stripe-go/v72/webhook"
-	_ "interviewTask/internal/data"
+	"go.opentelemetry.io/otel/attribute"
+	"interviewTask/internal/data"
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
+// only for testing handler purpose <> , set to false in production so the stripe calls are really made
+const stripeTestMode = true
+
+// errPaymentDeclined is returned when stripe refuses the card , the client should pick another card.
+var errPaymentDeclined = errors.New("payment declined")
+
+// stripePayment is what processStripePayment needs to charge a saved card.
+type stripePayment struct {
+	Amount          float64
+	UserID          int64
+	CustomerID      string
+	PaymentMethodID string
+	// IdempotencyKey is the client's Idempotency-Key , when set it is forwarded to stripe so a retried
+	// purchase can never be charged twice.
+	IdempotencyKey string
+}
+
+// stripePaymentResult is the state of the confirmed PaymentIntent , ClientSecret is only set when
+// the customer has to complete a 3-D Secure challenge (Status "requires_action").
+type stripePaymentResult struct {
+	PaymentIntentID string `json:"payment_intent_id"`
+	Status          string `json:"status"`
+	ClientSecret    string `json:"client_secret,omitempty"`
+}
+
+// succeeded reports whether the money was taken already , no webhook has to confirm it.
+func (r *stripePaymentResult) succeeded() bool {
+	return r.Status == string(stripe.PaymentIntentStatusSucceeded)
+}
+
 // process payments
-func (app *application) processStripePayment(amount float64, userId int64) (string, error) {
+// the PaymentIntent is created for the user's Stripe Customer with the saved card and confirmed right away.
+func (app *application) processStripePayment(ctx context.Context, payment stripePayment) (result *stripePaymentResult, err error) {
+	ctx, span := startStripeSpan(ctx, "PaymentIntent.create", attribute.Int64("buy.user_id", payment.UserID))
+	defer func() { endSpan(span, err) }()
 
-	/// only for testing handler purpose <> , set to false in production or using .env to pass it
-	testing := true
-	if testing {
-		app.logger.PrintInfo("testing variable is set to true please change it in production", map[string]string{"tetsing": "true"})
-		return "dummy_payment_id", nil
+	if stripeTestMode {
+		app.logger.PrintInfoContext(ctx, "testing variable is set to true please change it in production", map[string]string{"tetsing": "true"})
+		return &stripePaymentResult{PaymentIntentID: "dummy_payment_id", Status: string(stripe.PaymentIntentStatusSucceeded)}, nil
 	}
 	// end testing edit </>
 
 	// Convert amount to the smallest currency unit (cents for USD).
-	amountCents := int64(amount * 100)
+	amountCents := int64(payment.Amount * 100)
 
 	// Set your Stripe secret key from the configuration.
 	stripe.Key = app.config.stripeSecretKey //  .env
 	// prevent duplicate purchase
-	idempotencyKey := fmt.Sprintf("orderTime__%s__userID__%v", time.Now().Format(time.RFC3339), userId)
+	idempotencyKey := fmt.Sprintf("orderTime__%s__userID__%v", time.Now().Format(time.RFC3339), payment.UserID)
+	if payment.IdempotencyKey != "" {
+		idempotencyKey = fmt.Sprintf("userID__%v__%s", payment.UserID, payment.IdempotencyKey)
+	}
 	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amountCents),
-		Currency: stripe.String(string(stripe.CurrencyUSD)),
+		Amount:        stripe.Int64(amountCents),
+		Currency:      stripe.String(string(stripe.CurrencyUSD)),
+		Customer:      stripe.String(payment.CustomerID),
+		PaymentMethod: stripe.String(payment.PaymentMethodID),
+		Confirm:       stripe.Bool(true),
 	}
 	params.IdempotencyKey = stripe.String(idempotencyKey)
 
 	pi, err := paymentintent.New(params)
 	if err != nil {
-		return "", fmt.Errorf("stripe payment creation failed: %w", err)
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+			return nil, fmt.Errorf("%w: %s", errPaymentDeclined, stripeErr.Msg)
+		}
+		return nil, fmt.Errorf("stripe payment creation failed: %w", err)
+	}
+
+	result = &stripePaymentResult{PaymentIntentID: pi.ID, Status: string(pi.Status)}
+	if pi.Status == stripe.PaymentIntentStatusRequiresAction {
+		result.ClientSecret = pi.ClientSecret
+	}
+	return result, nil
+}
+
+// ensureStripeCustomer returns the user's Stripe Customer , creating and saving it on first use.
+func (app *application) ensureStripeCustomer(ctx context.Context, user *data.User) (customerID string, err error) {
+	if user.StripeCustomerID != "" {
+		return user.StripeCustomerID, nil
+	}
+
+	ctx, span := startStripeSpan(ctx, "Customer.create", attribute.Int64("buy.user_id", user.ID))
+	defer func() { endSpan(span, err) }()
+
+	if stripeTestMode {
+		return "dummy_customer_id", nil
+	}
+
+	stripe.Key = app.config.stripeSecretKey
+	params := &stripe.CustomerParams{
+		Email: stripe.String(user.Email),
+		Name:  stripe.String(user.FirstName),
+	}
+	params.AddMetadata("user_id", fmt.Sprint(user.ID))
+	params.IdempotencyKey = stripe.String(fmt.Sprintf("customer__userID__%v", user.ID))
+
+	c, err := customer.New(params)
+	if err != nil {
+		return "", fmt.Errorf("stripe customer creation failed: %w", err)
+	}
+
+	err = app.models.Users.SetStripeCustomerID(ctx, user.ID, c.ID)
+	if err != nil {
+		return "", err
+	}
+	user.StripeCustomerID = c.ID
+	return c.ID, nil
+}
+
+// errInvalidCardToken is returned when stripe does not recognise the card token.
+var errInvalidCardToken = errors.New("invalid card token")
+
+// stripeCard is the card metadata stripe reports for an attached PaymentMethod.
+type stripeCard struct {
+	PaymentMethodID string
+	Brand           string
+	Last4           string
+	ExpiryDate      time.Time // last day of the expiry month
+}
+
+// attachStripeCard validates the card token with stripe and attaches the card to the customer , the token
+// is either a PaymentMethod ID ("pm_...") from Stripe.js or a legacy card token ("tok_...").
+func (app *application) attachStripeCard(ctx context.Context, customerID, cardToken string) (card *stripeCard, err error) {
+	_, span := startStripeSpan(ctx, "PaymentMethod.attach")
+	defer func() { endSpan(span, err) }()
+
+	if stripeTestMode {
+		return &stripeCard{PaymentMethodID: cardToken, Brand: "visa", Last4: "4242"}, nil
+	}
+
+	stripe.Key = app.config.stripeSecretKey
+
+	paymentMethodID := cardToken
+	if !strings.HasPrefix(cardToken, "pm_") {
+		pm, err := paymentmethod.New(&stripe.PaymentMethodParams{
+			Type: stripe.String(string(stripe.PaymentMethodTypeCard)),
+			Card: &stripe.PaymentMethodCardParams{Token: stripe.String(cardToken)},
+		})
+		if err != nil {
+			return nil, stripeCardError(err)
+		}
+		paymentMethodID = pm.ID
+	}
+
+	pm, err := paymentmethod.Attach(paymentMethodID, &stripe.PaymentMethodAttachParams{
+		Customer: stripe.String(customerID),
+	})
+	if err != nil {
+		return nil, stripeCardError(err)
+	}
+	if pm.Card == nil {
+		return nil, errInvalidCardToken
+	}
+
+	return &stripeCard{
+		PaymentMethodID: pm.ID,
+		Brand:           string(pm.Card.Brand),
+		Last4:           pm.Card.Last4,
+		ExpiryDate:      time.Date(int(pm.Card.ExpYear), time.Month(pm.Card.ExpMonth)+1, 0, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+// stripeCardError turns the errors stripe returns for a bad token or card into errInvalidCardToken.
+func stripeCardError(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && (stripeErr.Type == stripe.ErrorTypeCard || stripeErr.Type == stripe.ErrorTypeInvalidRequest) {
+		return fmt.Errorf("%w: %s", errInvalidCardToken, stripeErr.Msg)
 	}
+	return fmt.Errorf("stripe payment method attach failed: %w", err)
+}
+
+// detachStripeCard removes a deleted card from the user's Stripe Customer.
+func (app *application) detachStripeCard(ctx context.Context, paymentMethodID string) (err error) {
+	if paymentMethodID == "" {
+		return nil
+	}
+
+	_, span := startStripeSpan(ctx, "PaymentMethod.detach")
+	defer func() { endSpan(span, err) }()
 
-	return pi.ID, nil
+	if stripeTestMode {
+		return nil
+	}
+
+	stripe.Key = app.config.stripeSecretKey
+	_, err = paymentmethod.Detach(paymentMethodID, nil)
+	if err != nil {
+		return fmt.Errorf("stripe payment method detach failed: %w", err)
+	}
+	return nil
+}
+
+// cancelStripePayment gives the money of a payment back , stripe is asked for the state of the PaymentIntent
+// since the order may not have heard of it yet (the webhook comes later). A succeeded intent is refunded in
+// full , a cancelled one is left alone and any other is cancelled.
+func (app *application) cancelStripePayment(ctx context.Context, paymentIntentID string) (err error) {
+	ctx, span := startStripeSpan(ctx, "PaymentIntent.cancel_or_refund", attribute.String("buy.payment_intent_id", paymentIntentID))
+	defer func() { endSpan(span, err) }()
+
+	if stripeTestMode {
+		app.logger.PrintInfoContext(ctx, "testing variable is set to true please change it in production", map[string]string{"tetsing": "true"})
+		return nil
+	}
+
+	stripe.Key = app.config.stripeSecretKey
+
+	pi, err := paymentintent.Get(paymentIntentID, nil)
+	if err != nil {
+		return fmt.Errorf("stripe payment lookup failed: %w", err)
+	}
+	span.SetAttributes(attribute.String("buy.payment_intent_status", string(pi.Status)))
+
+	switch pi.Status {
+	case stripe.PaymentIntentStatusCanceled:
+	case stripe.PaymentIntentStatusSucceeded:
+		params := &stripe.RefundParams{
+			PaymentIntent: stripe.String(pi.ID),
+		}
+		params.IdempotencyKey = stripe.String(fmt.Sprintf("refund__%s", pi.ID))
+		_, err = refund.New(params)
+		if err != nil {
+			return fmt.Errorf("stripe refund failed: %w", err)
+		}
+	default:
+		_, err = paymentintent.Cancel(pi.ID, nil)
+		if err != nil {
+			return fmt.Errorf("stripe payment cancellation failed: %w", err)
+		}
+	}
+	return nil
 }
 
 // listen to stripe
@@ -69,6 +277,11 @@ func (app *application) stripeWebhookHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	app.metrics.stripeEvents.WithLabelValues(string(event.Type)).Inc()
+
+	// Stripe only retries a failed delivery , the update must not stop when it hangs up on us.
+	ctx := context.WithoutCancel(r.Context())
+
 	// Process the event based on its type.
 	switch event.Type {
 	case "payment_intent.succeeded":
@@ -78,11 +291,16 @@ func (app *application) stripeWebhookHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 		// Update order status to "paid" using the PaymentIntent ID.
-		if err := app.models.Orders.UpdateStatusByStripePaymentID(pi.ID, "paid"); err != nil {
+		err := app.models.Orders.UpdateStatusByStripePaymentID(ctx, pi.ID, "paid")
+		if errors.Is(err, data.ErrInsufficientStock) {
+			// the order failed before and its stock was sold since , it stays failed and the money goes back.
+			err = app.cancelStripePayment(ctx, pi.ID)
+		}
+		if err != nil {
 			// Log the error or handle it appropriately.
-			app.logger.PrintError(err, map[string]string{"payment_intent_id": pi.ID})
+			app.logger.PrintErrorContext(ctx, err, map[string]string{"payment_intent_id": pi.ID})
 		}
-		app.logger.PrintInfo("payment_intent succeeded", map[string]string{"payment_intent_id": pi.ID})
+		app.logger.PrintInfoContext(ctx, "payment_intent succeeded", map[string]string{"payment_intent_id": pi.ID})
 
 	case "payment_intent.payment_failed":
 		var pi stripe.PaymentIntent
@@ -90,13 +308,14 @@ func (app *application) stripeWebhookHandler(w http.ResponseWriter, r *http.Requ
 			app.badRequestResponse(w, r, err)
 			return
 		}
+		app.metrics.paymentFailures.WithLabelValues("webhook").Inc()
 		// Update order status to "failed" based on the PaymentIntent ID.
-		if err := app.models.Orders.UpdateStatusByStripePaymentID(pi.ID, "failed"); err != nil {
-			app.logger.PrintError(err, map[string]string{"payment_intent_id": pi.ID})
+		if err := app.models.Orders.UpdateStatusByStripePaymentID(ctx, pi.ID, "failed"); err != nil {
+			app.logger.PrintErrorContext(ctx, err, map[string]string{"payment_intent_id": pi.ID})
 		}
-		app.logger.PrintInfo("payment_intent failed", map[string]string{"payment_intent_id": pi.ID})
+		app.logger.PrintInfoContext(ctx, "payment_intent failed", map[string]string{"payment_intent_id": pi.ID})
 	default:
-		app.logger.PrintInfo("unhandled event type", map[string]string{"type": event.Type})
+		app.logger.PrintInfoContext(ctx, "unhandled event type", map[string]string{"type": event.Type})
 	}
 
 	// Acknowledge receipt of the event.
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"interviewTask/internal/authentication"
@@ -53,8 +54,8 @@ func (app *application) SignUpUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert the new user into the database.
-	// (Assumes app.models.Users.Insert(user) is implemented in your data layer.)
-	err = app.models.Users.Insert(user)
+	// (Assumes app.models.Users.Insert(r.Context(), user) is implemented in your data layer.)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		if errors.Is(err, data.ErrDuplicateEmail) {
 			app.errorResponse(w, r, http.StatusConflict, "email already in use")
@@ -64,6 +65,7 @@ func (app *application) SignUpUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, data.AuditUserSignedUp, user.ID, map[string]interface{}{"email": user.Email, "role": user.Role})
 
 	// Return the created user as a JSON response.
 	// The password field is omitted due to the json:"-" tag.
@@ -86,30 +88,108 @@ func (app *application) LoginUser(w http.ResponseWriter, r *http.Request) {
 
 	// Sanitize input.
 	input.Email = validator.SanitizeString(input.Email)
+	ip := app.actor(r).IP
 
-	// Retrieve the user record by email.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// Refuse the attempt while the account or the IP is delayed or locked out by earlier failures , an
+	// attempt let through counts as a failure until the password turns out right.
+	attempt, retryAfter, err := app.models.Logins.Begin(r.Context(), input.Email, ip, app.config.login)
 	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.invalidCredentialsResponse(w, r)
-		} else {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.audit(r, data.AuditLoginFailed, 0, map[string]interface{}{"email": input.Email, "reason": "throttled"})
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	// Retrieve the user record by email , an unknown email still pays for a password check
+	// so the response time does not tell it apart from a wrong password.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Verify the provided password against the stored hash.
+	valid := false
+	if user != nil {
+		valid, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		valid = data.DummyPasswordMatches(input.Password)
+	}
+
+	if !valid {
+		var userID int64
+		reason := "unknown email"
+		if user != nil {
+			userID, reason = user.ID, "wrong password"
+		}
+
+		// hanging up before the answer must not spare the owner the lockout notification.
+		failures, err := app.models.Logins.RecordFailure(context.WithoutCancel(r.Context()), attempt, userID, app.config.login)
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.audit(r, data.AuditLoginFailed, userID, map[string]interface{}{"email": input.Email, "reason": reason, "failures": failures})
+		if failures == app.config.login.MaxFailures && user != nil {
+			app.audit(r, data.AuditAccountLocked, userID, map[string]interface{}{"lockout": app.config.login.Lockout.String()})
 		}
+		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// Verify the provided password against the stored hash.
-	valid, err := user.Password.Matches(input.Password)
+	err = app.models.Logins.Forget(r.Context(), attempt)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if !valid {
-		app.invalidCredentialsResponse(w, r)
+	app.firstFactorPassed(w, r, user)
+}
+
+// firstFactorPassed logs in a user who proved the password (or signed in with an identity provider).
+// With a second factor this only earns a short lived token to exchange at /user/login/mfa , the failed
+// logins are kept until then so guessing codes stays throttled.
+func (app *application) firstFactorPassed(w http.ResponseWriter, r *http.Request, user *data.User) {
+	totp, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if totp != nil && totp.Confirmed() {
+		mfaToken, err := auth.GenerateMFAToken(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.writeJson(w, http.StatusOK, envelope{"mfa_required": true, "mfa_token": mfaToken}, nil)
+		return
+	}
+
+	app.completeLogin(w, r, user, "")
+}
+
+// completeLogin clears the failed logins of the user and hands out their access token , mfa is how
+// the second factor was checked ("" when the user has none).
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, mfa string) {
+	err := app.models.Logins.Reset(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var metadata map[string]interface{}
+	if mfa != "" {
+		metadata = map[string]interface{}{"mfa": mfa}
+	}
+	app.audit(r, data.AuditLoginSucceeded, user.ID, metadata)
 
-	token, err := auth.GenerateToken(user.ID, user.Role)
+	token, err := auth.GenerateToken(user.ID, user.Role, mfa != "")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -125,18 +205,34 @@ func (app *application) GetPurchaseHistory(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Read the optional filters and the cursor of the page.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.HistoryFilters
+	filters.From = app.readDate(qs, "from", v)
+	filters.To = app.readDate(qs, "to", v)
+	filters.Status = app.readString(qs, "status", "")
+	filters.Cursor = app.readString(qs, "cursor", "")
+	filters.Limit = app.readInt(qs, "limit", 20, v)
+
+	if data.ValidateHistoryFilters(v, &filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
 	// Retrieve the detailed purchase history (including product info) from the Orders model.
-	history, err := app.models.Orders.GetPurchaseHistory(userID)
+	history, metadata, err := app.models.Orders.GetPurchaseHistory(r.Context(), userID, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Return the purchase history as a JSON response.
-	app.writeJson(w, http.StatusOK, envelope{"purchase_history": history}, nil)
+	app.writeJson(w, http.StatusOK, envelope{"purchase_history": history, "metadata": metadata}, nil)
 }
 
-func (app application) BuyProducts(w http.ResponseWriter, r *http.Request) {
+func (app *application) BuyProducts(w http.ResponseWriter, r *http.Request) {
 
 	// Define the expected JSON payload.
 	var input struct {
@@ -144,6 +240,7 @@ func (app application) BuyProducts(w http.ResponseWriter, r *http.Request) {
 			ID       int64 `json:"id"`
 			Quantity int   `json:"quantity"`
 		} `json:"products"`
+		CreditCardID int64 `json:"credit_card_id"`
 	}
 
 	// Decode the JSON request body.
@@ -156,6 +253,7 @@ func (app application) BuyProducts(w http.ResponseWriter, r *http.Request) {
 	// initialize v a new validator , check the len of the products
 	v := validator.New()
 	v.Check(len(input.Products) > 0, "products", "should be at least one product")
+	v.Check(input.CreditCardID > 0, "credit_card_id", "must be provided")
 	if !v.Valid() {
 		app.validationErrorResponse(w, r, v.Errors)
 		return
@@ -164,26 +262,67 @@ func (app application) BuyProducts(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the authenticated user ID.
 	userID, ok := r.Context().Value(userContextKey).(int64)
 	if !ok {
-		app.logger.PrintInfo(fmt.Sprintf("user is now is : %s", userID), nil)
+		app.logger.PrintInfo(fmt.Sprintf("user is now is : %d", userID), nil)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
+	// The card must be one of the caller's saved cards.
+	card, err := app.models.Creditcard.GetForUser(r.Context(), input.CreditCardID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("credit_card_id", "no saved credit card with this id")
+			app.validationErrorResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if card.Expired() {
+		v.AddError("credit_card_id", "card has expired")
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+	// cards saved before they were attached to a Stripe customer have nothing stripe can charge.
+	if card.StripePaymentMethodID == "" {
+		v.AddError("credit_card_id", "card must be re-added")
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	customerID, err := app.ensureStripeCustomer(r.Context(), user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Prepare the order and calculate the total amount.
 	order := &data.Order{
-		UserID: userID,
+		UserID:       userID,
+		CreditCardID: &card.ID,
 	}
 	var orderProducts []data.OrderProduct
 	var totalAmount float64
 
 	for _, p := range input.Products {
-		product, err := app.models.Product.GetByID(p.ID)
+		product, err := app.models.Product.GetByID(r.Context(), p.ID)
 		if err != nil {
-			app.logger.PrintInfo(fmt.Sprintf("error is: %s", err), nil)
+			app.logger.PrintInfoContext(r.Context(), fmt.Sprintf("error is: %s", err), nil)
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
+		// check the stock before charging , Orders.Create checks it again inside the transaction.
+		if product.InventoryCount < p.Quantity {
+			app.insufficientStockResponse(w, r)
+			return
+		}
+
 		lineTotal := product.Price * float64(p.Quantity)
 		totalAmount += lineTotal
 
@@ -195,26 +334,61 @@ func (app application) BuyProducts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Process the payment with Stripe.
-	stripePaymentID, err := app.processStripePayment(totalAmount, userID)
+	idempotencyKey, _ := r.Context().Value(idempotencyKeyContextKey).(string)
+	payment, err := app.processStripePayment(r.Context(), stripePayment{
+		Amount:          totalAmount,
+		UserID:          userID,
+		CustomerID:      customerID,
+		PaymentMethodID: card.StripePaymentMethodID,
+		IdempotencyKey:  idempotencyKey,
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		if errors.Is(err, errPaymentDeclined) {
+			app.metrics.paymentFailures.WithLabelValues("checkout").Inc()
+			app.paymentDeclinedResponse(w, r, err)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	// Set order details.
 	order.TotalAmount = totalAmount
-	order.StripePaymentID = stripePaymentID
+	order.StripePaymentID = payment.PaymentIntentID
+	// a payment that already succeeded makes the order paid now , so it can be refunded before the webhook.
+	if payment.succeeded() {
+		order.Status = data.OrderStatusPaid
+	}
 
-	// Insert the order and associated order_products records.
-	err = app.models.Orders.Create(order, orderProducts)
+	// Insert the order and associated order_products records , the card is charged already so a client
+	// hanging up must not cancel it.
+	err = app.models.Orders.Create(context.WithoutCancel(r.Context()), order, orderProducts)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		// without an order the money goes back , this is where a lost race for the last items ends.
+		refundErr := app.cancelStripePayment(context.WithoutCancel(r.Context()), payment.PaymentIntentID)
+		if refundErr != nil {
+			app.serverErrorResponse(w, r, fmt.Errorf("order not saved (%v) and payment %s not given back: %w",
+				err, payment.PaymentIntentID, refundErr))
+			return
+		}
+		if errors.Is(err, data.ErrInsufficientStock) {
+			app.insufficientStockResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+	app.metrics.ordersCreated.Inc()
 
-	// Respond with the order details.
-	app.writeJson(w, http.StatusCreated, envelope{
+	// Respond with the order details , when 3-D Secure is required the order stays pending until
+	// the client completes the challenge with the client secret and stripe calls the webhook.
+	status := http.StatusCreated
+	if payment.ClientSecret != "" {
+		status = http.StatusAccepted
+	}
+	app.writeJson(w, status, envelope{
 		"order":          order,
 		"order_products": orderProducts,
+		"payment":        payment,
 	}, nil)
 }
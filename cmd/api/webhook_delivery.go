@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"interviewTask/internal/data"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// webhook deliveries are sent by the job queue , one job per attempt. A 2xx answer is a success , anything else
+// (redirects included) is retried with exponential backoff until -webhook-max-attempts.
+//
+// Every request carries:
+//
+//	Webhook-Id: <event id> , the same on every attempt and redelivery , partners dedupe on it
+//	Webhook-Event: <event type>
+//	Webhook-Timestamp: <unix seconds>
+//	Webhook-Signature: t=<timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret>
+
+const (
+	webhookFirstRetry    = 30 * time.Second
+	webhookMaxRetry      = 6 * time.Hour
+	webhookResponseLimit = 1024 // bytes of the partner's answer kept in the log
+)
+
+var errPrivateAddress = errors.New("webhook url resolves to a private address")
+
+// webhookBody is what partners receive.
+type webhookBody struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// newWebhookClient returns the client deliveries are sent with. Unless allowPrivate is set it refuses to
+// connect to loopback, private and link local addresses , a subscription must not reach our own network.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deliverWebhook returns the handler of the JobDeliverWebhook jobs , one attempt at a delivery per job.
+// A failed attempt queues the next job itself , at its own backoff rather than the queue's.
+func (app *application) deliverWebhook(client *http.Client) func(context.Context, data.WebhookDeliveryJob) error {
+	return func(ctx context.Context, job data.WebhookDeliveryJob) error {
+		d, err := app.models.Webhooks.Claim(ctx, job.DeliveryID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		attempt, ok := sendWebhook(ctx, client, d, time.Now())
+		result := "failure"
+		if ok {
+			result = "success"
+		}
+		app.metrics.webhookDeliveries.WithLabelValues(d.EventType, result).Inc()
+
+		var retryAt *time.Time
+		if !ok && d.Attempts < app.config.webhooks.maxAttempts {
+			at := time.Now().Add(webhookBackoff(d.Attempts))
+			retryAt = &at
+		}
+
+		err = app.models.Webhooks.RecordAttempt(ctx, d.ID, attempt, ok, retryAt)
+		if err != nil {
+			return err
+		}
+		if !ok && retryAt == nil {
+			app.logger.PrintInfoContext(ctx, "webhook delivery failed for good", map[string]string{
+				"job":         data.JobDeliverWebhook,
+				"delivery_id": fmt.Sprint(d.ID),
+				"attempts":    fmt.Sprint(d.Attempts),
+			})
+		}
+		return nil
+	}
+}
+
+// sendWebhook posts the signed event and reports whether the partner accepted it.
+func sendWebhook(ctx context.Context, client *http.Client, d *data.DueDelivery, now time.Time) (*data.WebhookAttempt, bool) {
+	attempt := &data.WebhookAttempt{}
+
+	body, err := json.Marshal(webhookBody{ID: d.EventID, Type: d.EventType, CreatedAt: d.EventCreatedAt, Data: d.Payload})
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "buy-webhooks/"+version)
+	req.Header.Set("Webhook-Id", strconv.FormatInt(d.EventID, 10))
+	req.Header.Set("Webhook-Event", d.EventType)
+	req.Header.Set("Webhook-Timestamp", timestamp)
+	req.Header.Set("Webhook-Signature", "t="+timestamp+",v1="+signWebhook(d.Secret, timestamp, body))
+
+	start := time.Now()
+	res, err := client.Do(req)
+	attempt.DurationMS = int(time.Since(start).Milliseconds())
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	defer res.Body.Close()
+
+	attempt.StatusCode = &res.StatusCode
+	answer, _ := io.ReadAll(io.LimitReader(res.Body, webhookResponseLimit))
+	attempt.ResponseBody = string(bytes.ToValidUTF8(answer, nil))
+
+	return attempt, res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+// signWebhook is the hex HMAC-SHA256 of "<timestamp>.<body>" , the timestamp is signed so a captured
+// request can not be replayed later with a new one.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait after the given failed attempt , doubling from webhookFirstRetry up to
+// webhookMaxRetry , with up to 20% jitter so failed deliveries do not all come back at once.
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookFirstRetry
+	for i := 1; i < attempts && wait < webhookMaxRetry; i++ {
+		wait *= 2
+	}
+	if wait > webhookMaxRetry {
+		wait = webhookMaxRetry
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
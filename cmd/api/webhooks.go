@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+// CreateWebhook subscribes a partner endpoint to events , the signing secret is in the response and never shown again.
+func (app *application) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+		Active      *bool    `json:"active"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	actor := app.actor(r)
+	sub := &data.WebhookSubscription{
+		URL:         input.URL,
+		Events:      input.Events,
+		Description: validator.SanitizeString(input.Description),
+		Active:      input.Active == nil || *input.Active,
+		CreatedBy:   &actor.UserID,
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, sub); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	sub.Secret, err = data.GenerateWebhookSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(r.Context(), sub, actor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusCreated, envelope{"webhook": sub, "secret": sub.Secret}, nil)
+}
+
+func (app *application) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs, err := app.models.Webhooks.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"webhooks": subs}, nil)
+}
+
+// UpdateWebhook changes the given fields of a subscription , deactivating it pauses its deliveries.
+func (app *application) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		URL         *string  `json:"url"`
+		Events      []string `json:"events"`
+		Description *string  `json:"description"`
+		Active      *bool    `json:"active"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sub, err := app.models.Webhooks.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.URL != nil {
+		sub.URL = *input.URL
+	}
+	if input.Events != nil {
+		sub.Events = input.Events
+	}
+	if input.Description != nil {
+		sub.Description = validator.SanitizeString(*input.Description)
+	}
+	if input.Active != nil {
+		sub.Active = *input.Active
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, sub); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(r.Context(), sub, app.actor(r))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"webhook": sub}, nil)
+}
+
+func (app *application) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(r.Context(), id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"message": "webhook deleted"}, nil)
+}
+
+// ListWebhookDeliveries is the delivery log of a subscription , ?status= narrows it down.
+func (app *application) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 50, v)
+	filters.Sort = "-created_at"
+	filters.SortSafelist = []string{"-created_at"}
+
+	if status != "" {
+		v.Check(validator.In(status, data.DeliveryStatuses...), "status", "invalid status")
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	if _, err = app.models.Webhooks.Get(r.Context(), id); err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(r.Context(), id, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+}
+
+// ShowWebhookDelivery returns a delivery with its payload and every attempt made.
+func (app *application) ShowWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	delivery, err := app.models.Webhooks.GetDelivery(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"delivery": delivery}, nil)
+}
+
+// RedeliverWebhook queues a delivery to be sent again , the partner sees the same event id.
+func (app *application) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Webhooks.Redeliver(r.Context(), id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusAccepted, envelope{"message": "delivery queued"}, nil)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"interviewTask/internal/data"
+	"interviewTask/internal/validator"
+	"net/http"
+)
+
+func (app *application) ListWishlist(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	items, err := app.models.Wishlist.GetAll(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"wishlist": items}, nil)
+}
+
+func (app *application) AddToWishlist(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProductID         int64 `json:"product_id"`
+		NotifyBackInStock *bool `json:"notify_back_in_stock"` // defaults to true
+	}
+
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ProductID > 0, "product_id", "must be provided")
+	if !v.Valid() {
+		app.validationErrorResponse(w, r, v.Errors)
+		return
+	}
+
+	notify := true
+	if input.NotifyBackInStock != nil {
+		notify = *input.NotifyBackInStock
+	}
+
+	err = app.models.Wishlist.Add(r.Context(), userID, input.ProductID, notify)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	items, err := app.models.Wishlist.GetAll(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJson(w, http.StatusCreated, envelope{"wishlist": items}, nil)
+}
+
+func (app *application) RemoveFromWishlist(w http.ResponseWriter, r *http.Request) {
+	// the id in the url is the product id.
+	productID, err := app.readIDparam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, ok := r.Context().Value(userContextKey).(int64)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Wishlist.Remove(r.Context(), userID, productID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, envelope{"message": "product removed from wishlist"}, nil)
+}
@@ -0,0 +1,68 @@
+// Command backfill rebuilds the daily sales rollups from the orders , run it once after the migration
+// that creates them and whenever a range of days needs to be recomputed.
+//
+//	go run ./cmd/backfill -from 2024-01-01 -to 2024-12-31
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+
+	"interviewTask/internal/data"
+	"interviewTask/internal/jsonlog"
+)
+
+func main() {
+	// the .env file is optional here , the DSN can be passed as a flag.
+	_ = godotenv.Load()
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+
+	var dsn, from, to string
+	flag.StringVar(&dsn, "db-dsn", os.Getenv("DSN_BUY_DB"), "postgresSql")
+	flag.StringVar(&from, "from", "2000-01-01", "first day (UTC) to rebuild , YYYY-MM-DD")
+	flag.StringVar(&to, "to", yesterday, "last day (UTC) to rebuild , YYYY-MM-DD")
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	fromDay, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		logger.PrintFatal(err, map[string]string{"flag": "from"})
+	}
+	toDay, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		logger.PrintFatal(err, map[string]string{"flag": "to"})
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	models := data.NewModel(db)
+	days, err := models.SalesRollup.Rebuild(context.Background(), fromDay, toDay)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("sales rollups rebuilt", map[string]string{
+		"from": from,
+		"to":   to,
+		"days": fmt.Sprint(days),
+	})
+}
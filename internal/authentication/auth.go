@@ -1,34 +1,188 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
-	"os"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 var TokenExpiry = time.Hour * 72
 
-func GenerateToken(userID int64, role string) (string, error) {
-	claims := jwt.MapClaims{
-		"sub":  userID,                             // subject: user ID
-		"role": role,                               // include role for authorization checks
-		"exp":  time.Now().Add(TokenExpiry).Unix(), // expiry time
-		"iat":  time.Now().Unix(),                  // issued at
+// MFATokenExpiry is how long the user has to enter their second factor after the password.
+var MFATokenExpiry = time.Minute * 5
+
+// mfaPendingPurpose marks a token that only proves the password , it is exchanged for a real token
+// with a second factor and is refused everywhere else.
+const mfaPendingPurpose = "mfa_pending"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Policy is what every token is issued with and checked against.
+type Policy struct {
+	Issuer   string        // iss of our tokens
+	Audience string        // aud of our tokens , the API they are meant for
+	Leeway   time.Duration // clock skew tolerated on exp, nbf and iat
+}
+
+// policy is the Policy in use , installed by UsePolicy at startup.
+var policy = Policy{Issuer: "buy", Audience: "buy-api", Leeway: 30 * time.Second}
+
+// UsePolicy sets the issuer, audience and clock skew tokens are issued and validated with.
+func UsePolicy(p Policy) {
+	policy = p
+}
+
+// Claims are the claims of our tokens. The subject is the user ID as a string , the session ID
+// names the login the token came from.
+type Claims struct {
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	MFA       bool   `json:"mfa,omitempty"`     // second factor checked at login
+	Purpose   string `json:"purpose,omitempty"` // empty for an access token
+	jwt.RegisteredClaims
+}
+
+// UserID returns the subject as a user ID.
+func (c *Claims) UserID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return 0, ErrInvalidToken
+	}
+	return id, nil
+}
+
+// Valid checks the registered claims against the policy , it is called by the parser once the
+// signature is verified.
+func (c Claims) Valid() error {
+	now := jwt.TimeFunc()
+
+	switch {
+	case !c.VerifyExpiresAt(now.Add(-policy.Leeway), true):
+		return errors.New("token is expired")
+	case !c.VerifyNotBefore(now.Add(policy.Leeway), false):
+		return errors.New("token is not valid yet")
+	case !c.VerifyIssuedAt(now.Add(policy.Leeway), false):
+		return errors.New("token used before issued")
+	case !c.VerifyIssuer(policy.Issuer, true):
+		return errors.New("unexpected issuer")
+	case !c.VerifyAudience(policy.Audience, true):
+		return errors.New("unexpected audience")
+	case c.Subject == "":
+		return errors.New("missing subject")
+	}
+	return nil
+}
+
+// newClaims fills in the registered claims of a token for the user , valid from now for expiry.
+func newClaims(userID int64, expiry time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    policy.Issuer,
+			Subject:   strconv.FormatInt(userID, 10),
+			Audience:  jwt.ClaimStrings{policy.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+// GenerateToken issues the access token of the user , mfa tells whether a second factor was checked at login.
+// Every call starts a new session.
+func GenerateToken(userID int64, role string, mfa bool) (string, error) {
+	sid, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := newClaims(userID, TokenExpiry)
+	claims.Role = role
+	claims.SessionID = sid
+	claims.MFA = mfa
+	return sign(claims)
+}
+
+// GenerateMFAToken issues the short lived token handed out after the password of a user with a second factor.
+func GenerateMFAToken(userID int64) (string, error) {
+	claims := newClaims(userID, MFATokenExpiry)
+	claims.Purpose = mfaPendingPurpose
+	return sign(claims)
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+// Authenticate validates an access token and returns its claims , tokens issued for anything
+// else (like a pending second factor) are refused.
+func Authenticate(tokenStr string) (*Claims, error) {
+	claims, err := parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
 }
 
-func ValidateToken(tokenStr string) (*jwt.Token, error) {
-	return jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the signing method is HMAC (HS256).
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// ValidateMFAToken checks a token from GenerateMFAToken and returns the user it was issued to.
+func ValidateMFAToken(tokenStr string) (int64, error) {
+	claims, err := parse(tokenStr)
+	if err != nil {
+		return 0, err
+	}
+	if claims.Purpose != mfaPendingPurpose {
+		return 0, ErrInvalidToken
+	}
+	return claims.UserID()
+}
+
+// sign signs the claims with the current signing key and names it in the kid header.
+func sign(claims jwt.Claims) (string, error) {
+	if keys == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(keys.signing.method, claims)
+	token.Header["kid"] = keys.signing.kid
+	return token.SignedString(keys.signing.private)
+}
+
+// parse verifies the signature and the claims of a token , any failure is wrapped in ErrInvalidToken.
+func parse(tokenStr string) (*Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
+		if keys == nil {
+			return nil, ErrNoSigningKey
+		}
+
+		// Find the verification key named by the kid header , the algorithm must be the one of that key.
+		kid, _ := token.Header["kid"].(string)
+		k, ok := keys.verify[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != k.method.Alg() {
 			return nil, errors.New("unexpected signing method")
 		}
-		return jwtSecret, nil
+		return k.public, nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
 }
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// tokens are signed with a private key (RS256 or EdDSA) named by the kid header. Any number of
+// public keys verify them , so a new signing key can be rolled out while tokens signed by the
+// previous one stay valid until they expire.
+
+var ErrNoSigningKey = errors.New("no jwt signing key loaded")
+
+// minRSABits is the smallest RSA modulus accepted for signing or verifying.
+const minRSABits = 2048
+
+// key is one key pair (private only for the signing key) and the algorithm it is used with.
+type key struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet holds the signing key and the verification keys by kid , the signing key is one of them.
+type KeySet struct {
+	signing *key
+	verify  map[string]*key
+	order   []string // kids in the order they were loaded , for a stable JWKS
+}
+
+// keys is the key set tokens are signed and verified with , installed by UseKeys at startup.
+var keys *KeySet
+
+// UseKeys makes the key set the one GenerateToken and ValidateToken use.
+func UseKeys(ks *KeySet) {
+	keys = ks
+}
+
+// LoadKeys reads the PEM private key tokens are signed with and the extra PEM keys (public or
+// private) tokens are also verified with , usually the previous signing key during a rotation.
+func LoadKeys(signingFile string, verificationFiles []string) (*KeySet, error) {
+	if signingFile == "" {
+		return nil, ErrNoSigningKey
+	}
+
+	ks := &KeySet{verify: map[string]*key{}}
+
+	signing, err := readKey(signingFile)
+	if err != nil {
+		return nil, err
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("jwt signing key %s: not a private key", signingFile)
+	}
+	ks.signing = signing
+	ks.add(signing)
+
+	for _, file := range verificationFiles {
+		k, err := readKey(file)
+		if err != nil {
+			return nil, err
+		}
+		ks.add(k)
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) add(k *key) {
+	if _, ok := ks.verify[k.kid]; ok {
+		return
+	}
+	ks.verify[k.kid] = k
+	ks.order = append(ks.order, k.kid)
+}
+
+// SigningKeyID returns the kid new tokens are signed with.
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.kid
+}
+
+// readKey parses a PEM file holding an RSA or Ed25519 key , PKCS#8, PKCS#1 or PKIX.
+func readKey(file string) (*key, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("jwt key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("jwt key %s: no PEM data", file)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %s: %w", file, err)
+	}
+
+	var k key
+	if signer, ok := parsed.(crypto.Signer); ok {
+		k.private = signer
+		parsed = signer.Public()
+	}
+
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("jwt key %s: RSA keys must have at least %d bits", file, minRSABits)
+		}
+		k.method, k.public = jwt.SigningMethodRS256, pub
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, pub
+	default:
+		return nil, fmt.Errorf("jwt key %s: only RSA and Ed25519 keys are supported", file)
+	}
+
+	k.kid, err = thumbprint(k.public)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// JWK is the public part of a key as published in the JWKS (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS returns the public verification keys , for other services to check our tokens.
+func JWKS() []JWK {
+	if keys == nil {
+		return []JWK{}
+	}
+
+	jwks := make([]JWK, 0, len(keys.order))
+	for _, kid := range keys.order {
+		k := keys.verify[kid]
+		jwk := JWK{KeyID: k.kid, Use: "sig", Algorithm: k.method.Alg()}
+		jwk.KeyType, jwk.N, jwk.E, jwk.Curve, jwk.X = jwkFields(k.public)
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+func jwkFields(pub crypto.PublicKey) (kty, n, e, crv, x string) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes()), "", ""
+	case ed25519.PublicKey:
+		return "OKP", "", "", "Ed25519", b64(pub)
+	}
+	return "", "", "", "", ""
+}
+
+// thumbprint is the RFC 7638 thumbprint of the public key , used as its kid so every instance
+// loading the same key file names it the same way.
+func thumbprint(pub crypto.PublicKey) (string, error) {
+	kty, n, e, crv, x := jwkFields(pub)
+
+	// the required members in lexicographic order , no whitespace.
+	var members interface{}
+	switch kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{e, kty, n}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{crv, kty, x}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+
+	js, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(js)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"interviewTask/internal/validator"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyScopes are the scopes an API key can be given , "<resource>:read" reaches the GET admin
+// routes of the resource and "<resource>:write" the others.
+var APIKeyScopes = []string{
+	"products:read", "products:write",
+	"inventory:read", "inventory:write",
+	"orders:read", "orders:write",
+	"reviews:read", "reviews:write",
+	"reports:read",
+	"audit:read",
+	"webhooks:read", "webhooks:write",
+}
+
+// apiKeyPrefix starts every key so it is recognisable in configs and secret scanners.
+const apiKeyPrefix = "bk_"
+
+// APIKey is a key an integration authenticates with , the plain key is only known when it is created.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int64      `json:"created_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	Role string `json:"-"` // current role of the creator , filled in by Authenticate
+}
+
+// HasScope reports whether the key was given the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyModel wraps a sql.DB connection pool.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the key and returns it in plain text , the only time it is available.
+// The key looks like "bk_<prefix>_<secret>".
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey, actor Actor) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	prefix, err := randomToken(6, hex.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+	plain := apiKeyPrefix + prefix + "_" + secret
+	key.Prefix = prefix
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, key.Name, key.Prefix, hashAPIKey(plain), pq.Array(key.Scopes), key.CreatedBy, key.ExpiresAt).
+		Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditAPIKeyCreated, key.ID, nil, key, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// Authenticate returns the key matching the plain key , ErrInvalidAPIKey if it is unknown, expired or
+// revoked. It records the use in last_used_at (at most once a minute).
+func (m APIKeyModel) Authenticate(ctx context.Context, plain string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rest, ok := strings.CutPrefix(plain, apiKeyPrefix)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	query := `
+		SELECT k.id, k.name, k.prefix, k.key_hash, k.scopes, k.created_by, k.expires_at, k.last_used_at, k.created_at, u.role
+		FROM api_keys k
+		JOIN users u ON u.id = k.created_by
+		WHERE k.prefix = $1 AND k.revoked_at IS NULL AND k.expires_at > NOW()`
+
+	var key APIKey
+	var hash []byte
+	err := m.DB.QueryRowContext(ctx, query, prefix).Scan(&key.ID, &key.Name, &key.Prefix, &hash, pq.Array(&key.Scopes),
+		&key.CreatedBy, &key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt, &key.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hash, hashAPIKey(plain)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	query = `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	if _, err = m.DB.ExecContext(ctx, query, key.ID); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAll returns every key , newest first. Revoked and expired keys are kept for the record.
+func (m APIKeyModel) GetAll(ctx context.Context) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, name, prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys
+		ORDER BY created_at DESC, id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var k APIKey
+		err = rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.CreatedBy, &k.ExpiresAt, &k.LastUsedAt,
+			&k.RevokedAt, &k.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke stops the key from working , ErrRecordNotFound if it does not exist or is already revoked.
+func (m APIKeyModel) Revoke(ctx context.Context, id int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRowContext(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL RETURNING name`, id).
+		Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditAPIKeyRevoked, id, nil, nil, map[string]interface{}{"name": name})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func hashAPIKey(plain string) []byte {
+	sum := sha256.Sum256([]byte(plain))
+	return sum[:]
+}
+
+func randomToken(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not exceed 100 characters")
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one scope")
+	for _, scope := range key.Scopes {
+		v.Check(validator.In(scope, APIKeyScopes...), "scopes", "invalid scope "+scope)
+	}
+	v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	v.Check(key.ExpiresAt.Before(time.Now().AddDate(1, 0, 1)), "expires_at", "must be at most a year ahead")
+}
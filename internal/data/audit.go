@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"interviewTask/internal/validator"
+)
+
+// audit actions , "<target type>.<what happened>".
+const (
+	AuditProductCreated              = "product.created"
+	AuditProductUpdated              = "product.updated"
+	AuditProductDeleted              = "product.deleted"
+	AuditProductStockAdjusted        = "product.stock_adjusted"
+	AuditOrderFulfilled              = "order.fulfilled"
+	AuditOrderTrackingUpdated        = "order.tracking_updated"
+	AuditOrderCancelled              = "order.cancelled"
+	AuditReviewModerated             = "review.moderated"
+	AuditReviewDeleted               = "review.deleted"
+	AuditUserSignedUp                = "user.signed_up"
+	AuditIdentityLinked              = "user.identity_linked"
+	AuditLoginSucceeded              = "auth.login_succeeded"
+	AuditLoginFailed                 = "auth.login_failed"
+	AuditAccountLocked               = "auth.account_locked"
+	AuditAccessDenied                = "auth.access_denied"
+	AuditMFAEnabled                  = "auth.mfa_enabled"
+	AuditMFADisabled                 = "auth.mfa_disabled"
+	AuditMFARecoveryCodeUsed         = "auth.mfa_recovery_code_used"
+	AuditMFARecoveryCodesRegenerated = "auth.mfa_recovery_codes_regenerated"
+	AuditAPIKeyCreated               = "api_key.created"
+	AuditAPIKeyRevoked               = "api_key.revoked"
+	AuditWebhookCreated              = "webhook.created"
+	AuditWebhookUpdated              = "webhook.updated"
+	AuditWebhookDeleted              = "webhook.deleted"
+	AuditWebhookRedelivered          = "webhook_delivery.redelivered"
+	AuditJobRetried                  = "job.retried"
+)
+
+// Actor is who made a change and from where , every audit event records it.
+type Actor struct {
+	UserID    int64 // 0 for an anonymous request
+	APIKeyID  int64 // the API key the request authenticated with , 0 for a user token
+	IP        string
+	UserAgent string
+}
+
+// AuditEvent is one entry of the audit log. Before and After only hold the fields that changed ,
+// Before is null for a creation and After is null for a deletion.
+type AuditEvent struct {
+	ID          int64           `json:"id"`
+	ActorUserID *int64          `json:"actor_user_id,omitempty"`
+	APIKeyID    *int64          `json:"api_key_id,omitempty"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    *int64          `json:"target_id,omitempty"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	IP          string          `json:"ip"`
+	UserAgent   string          `json:"user_agent"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// AuditFilters narrows down the audit log listing.
+type AuditFilters struct {
+	ActorUserID int64
+	Action      string
+	TargetType  string
+	TargetID    int64
+	From        time.Time
+	To          time.Time
+	Filters
+}
+
+// AuditModel wraps a sql.DB connection pool.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordAudit writes an audit event , pass the transaction of the change so both commit or roll back together.
+// before and after are any JSON encodable values (nil for none) , only the fields that differ are kept.
+func recordAudit(ctx context.Context, q execer, actor Actor, action string, targetID int64, before, after interface{}, metadata map[string]interface{}) error {
+	b, a, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+
+	var meta []byte
+	if len(metadata) > 0 {
+		if meta, err = json.Marshal(metadata); err != nil {
+			return err
+		}
+	}
+
+	var actorID, apiKeyID, target *int64
+	if actor.UserID != 0 {
+		actorID = &actor.UserID
+	}
+	if actor.APIKeyID != 0 {
+		apiKeyID = &actor.APIKeyID
+	}
+	if targetID != 0 {
+		target = &targetID
+	}
+	targetType, _, _ := strings.Cut(action, ".")
+
+	query := `
+		INSERT INTO audit_events (actor_user_id, api_key_id, action, target_type, target_id, before, after, metadata, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::inet, $10)`
+	_, err = q.ExecContext(ctx, query, actorID, apiKeyID, action, targetType, target, nullJSON(b), nullJSON(a), nullJSON(meta),
+		actor.IP, actor.UserAgent)
+	return err
+}
+
+func nullJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+// auditDiff turns both values into JSON objects and drops the fields they have in common ,
+// updated_at always changes and is left out.
+func auditDiff(before, after interface{}) ([]byte, []byte, error) {
+	b, err := toJSONObject(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := toJSONObject(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b != nil && a != nil {
+		for key := range b {
+			if reflect.DeepEqual(b[key], a[key]) || key == "updated_at" {
+				delete(b, key)
+				delete(a, key)
+			}
+		}
+	}
+
+	var bj, aj []byte
+	if b != nil {
+		if bj, err = json.Marshal(b); err != nil {
+			return nil, nil, err
+		}
+	}
+	if a != nil {
+		if aj, err = json.Marshal(a); err != nil {
+			return nil, nil, err
+		}
+	}
+	return bj, aj, nil
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(js, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Record writes an audit event that is not part of a data change , like a login.
+func (m AuditModel) Record(ctx context.Context, actor Actor, action string, targetID int64, metadata map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return recordAudit(ctx, m.DB, actor, action, targetID, nil, nil, metadata)
+}
+
+// GetAll returns a page of the audit log matching the filters , newest first.
+func (m AuditModel) GetAll(ctx context.Context, filters AuditFilters) ([]*AuditEvent, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Build the where clause dynamically , every filter adds its own placeholder.
+	var conditions []string
+	var args []interface{}
+	addCondition := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filters.ActorUserID != 0 {
+		addCondition("actor_user_id = $%d", filters.ActorUserID)
+	}
+	if filters.Action != "" {
+		addCondition("action = $%d", filters.Action)
+	}
+	if filters.TargetType != "" {
+		addCondition("target_type = $%d", filters.TargetType)
+	}
+	if filters.TargetID != 0 {
+		addCondition("target_id = $%d", filters.TargetID)
+	}
+	if !filters.From.IsZero() {
+		addCondition("created_at >= $%d", filters.From)
+	}
+	if !filters.To.IsZero() {
+		// "to" is a calendar day , include all of it.
+		addCondition("created_at < $%d", filters.To.AddDate(0, 0, 1))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, actor_user_id, api_key_id, action, target_type, target_id, before, after, metadata,
+			COALESCE(host(ip), ''), user_agent, created_at, count(*) OVER()
+		FROM audit_events
+		%s
+		ORDER BY %s %s, id DESC
+		LIMIT $%d OFFSET $%d`,
+		where, filters.sortColumn(), filters.sortDirection(), len(args)+1, len(args)+2)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var before, after, metadata []byte
+		err = rows.Scan(&e.ID, &e.ActorUserID, &e.APIKeyID, &e.Action, &e.TargetType, &e.TargetID, &before, &after, &metadata,
+			&e.IP, &e.UserAgent, &e.CreatedAt, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		e.Before, e.After, e.Metadata = before, after, metadata
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return events, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func ValidateAuditFilters(v *validator.Validator, f AuditFilters) {
+	v.Check(f.ActorUserID >= 0, "actor_user_id", "must be a positive value")
+	v.Check(f.TargetID >= 0, "target_id", "must be a positive value")
+	v.Check(len(f.Action) <= 64, "action", "must not exceed 64 characters")
+	v.Check(len(f.TargetType) <= 32, "target_type", "must not exceed 32 characters")
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(!f.To.Before(f.From), "to", "must not be before from")
+	}
+	ValidateFilters(v, f.Filters)
+}
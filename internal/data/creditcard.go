@@ -3,17 +3,39 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
+
+	"interviewTask/internal/validator"
+)
+
+// card expiry statuses , kept up to date by the periodic expiry check.
+const (
+	CardExpiryValid    = "valid"
+	CardExpiryExpiring = "expiring"
+	CardExpiryExpired  = "expired"
 )
 
 // CreditCard represents a credit card record.
+// Only masked metadata is exposed in JSON , the token never leaves the server.
 type CreditCard struct {
 	ID             int64     `json:"id"`
 	UserID         int64     `json:"user_id"`
-	CardToken      string    `json:"card_token"`
+	CardToken      string    `json:"-"`
+	Brand          string    `json:"brand"`
+	Last4          string    `json:"last4"`
 	ExpiryDate     time.Time `json:"expiry_date"`
+	ExpiryStatus   string    `json:"expiry_status"`
 	CardholderName string    `json:"cardholder_name"`
+	IsDefault      bool      `json:"is_default"`
 	CreatedAt      time.Time `json:"created_at"`
+
+	StripePaymentMethodID string `json:"-"` // the card attached to the user's Stripe Customer
+}
+
+// Expired reports whether the card can no longer be charged , it is valid through the whole expiry day.
+func (c *CreditCard) Expired() bool {
+	return time.Now().After(c.ExpiryDate.AddDate(0, 0, 1))
 }
 
 // CreditCardModel wraps a sql.DB connection pool.
@@ -21,30 +43,130 @@ type CreditCardModel struct {
 	DB *sql.DB
 }
 
+const creditCardColumns = `
+	id, user_id, card_token, COALESCE(brand, ''), COALESCE(last4, ''), expiry_date, expiry_status,
+	COALESCE(cardholder_name, ''), is_default, created_at, COALESCE(stripe_payment_method_id, '')`
+
+func scanCreditCard(row rowScanner, card *CreditCard) error {
+	return row.Scan(&card.ID, &card.UserID, &card.CardToken, &card.Brand, &card.Last4, &card.ExpiryDate, &card.ExpiryStatus,
+		&card.CardholderName, &card.IsDefault, &card.CreatedAt, &card.StripePaymentMethodID)
+}
+
 // Insert adds a new credit card record to the database.
 // It uses a context with timeout to avoid hanging queries and returns
 // the generated ID and creation timestamp via the CreditCard struct.
-func (m CreditCardModel) Insert(card *CreditCard) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// The first card a user saves becomes their default card.
+func (m CreditCardModel) Insert(ctx context.Context, card *CreditCard) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
-		INSERT INTO credit_cards (user_id, card_token, expiry_date, cardholder_name, created_at)
-		VALUES ($1, $2, $3, $4, NOW())
-		RETURNING id, created_at
+		INSERT INTO credit_cards (user_id, card_token, brand, last4, expiry_date, cardholder_name, stripe_payment_method_id,
+			is_default, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7,
+			NOT EXISTS (SELECT 1 FROM credit_cards WHERE user_id = $1 AND is_default), NOW())
+		RETURNING id, is_default, expiry_status, created_at
 	`
-	err := m.DB.QueryRowContext(ctx, query, card.UserID, card.CardToken, card.ExpiryDate, card.CardholderName).
-		Scan(&card.ID, &card.CreatedAt)
+	err := m.DB.QueryRowContext(ctx, query, card.UserID, card.CardToken, card.Brand, card.Last4, card.ExpiryDate,
+		card.CardholderName, card.StripePaymentMethodID).
+		Scan(&card.ID, &card.IsDefault, &card.ExpiryStatus, &card.CreatedAt)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// GetForUser retrieves a credit card by its ID , only if it belongs to the given user.
+func (m CreditCardModel) GetForUser(ctx context.Context, id, userID int64) (*CreditCard, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + creditCardColumns + ` FROM credit_cards WHERE id = $1 AND user_id = $2`
+
+	var card CreditCard
+	err := scanCreditCard(m.DB.QueryRowContext(ctx, query, id, userID), &card)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &card, nil
+}
+
+// GetAllForUser lists the user's saved cards , default card first.
+func (m CreditCardModel) GetAllForUser(ctx context.Context, userID int64) ([]*CreditCard, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + creditCardColumns + `
+		FROM credit_cards
+		WHERE user_id = $1
+		ORDER BY is_default DESC, created_at DESC, id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []*CreditCard{}
+	for rows.Next() {
+		var card CreditCard
+		if err = scanCreditCard(rows, &card); err != nil {
+			return nil, err
+		}
+		cards = append(cards, &card)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+// SetDefault makes the card the user's default , clearing the flag on their other cards in the same transaction.
+func (m CreditCardModel) SetDefault(ctx context.Context, id, userID int64) (*CreditCard, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE credit_cards SET is_default = FALSE WHERE user_id = $1 AND is_default AND id <> $2`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE credit_cards
+		SET is_default = TRUE
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + creditCardColumns
+
+	var card CreditCard
+	err = scanCreditCard(tx.QueryRowContext(ctx, query, id, userID), &card)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
 // Delete removes a credit card record identified by its ID and associated userID.
 // It returns a specific ErrRecordNotFound if no record is deleted.
-func (m CreditCardModel) Delete(id, userID int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// When the default card is removed the most recent remaining card takes over.
+func (m CreditCardModel) Delete(ctx context.Context, id, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -64,5 +186,67 @@ func (m CreditCardModel) Delete(id, userID int64) error {
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
-	return nil
+
+	query = `
+		UPDATE credit_cards
+		SET is_default = TRUE
+		WHERE id = (SELECT id FROM credit_cards WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1)
+		AND NOT EXISTS (SELECT 1 FROM credit_cards WHERE user_id = $1 AND is_default)
+	`
+	_, err = m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// FlagExpiring marks the cards that expire within the given number of days as expiring (or expired once
+// the expiry day is over) and queues a notification for their owner , in one statement so a card is
+// only ever notified once per status change. It returns the number of cards flagged.
+func (m CreditCardModel) FlagExpiring(ctx context.Context, withinDays int) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		WITH flagged AS (
+			UPDATE credit_cards
+			SET expiry_status = CASE WHEN expiry_date < CURRENT_DATE THEN $2 ELSE $3 END
+			WHERE expiry_date <= CURRENT_DATE + $1::int
+			AND expiry_status <> CASE WHEN expiry_date < CURRENT_DATE THEN $2 ELSE $3 END
+			RETURNING id, user_id, brand, last4, expiry_date, expiry_status
+		)
+		INSERT INTO notifications_outbox (user_id, kind, payload)
+		SELECT user_id,
+			CASE WHEN expiry_status = $2 THEN $4 ELSE $5 END,
+			jsonb_build_object('credit_card_id', id, 'brand', brand, 'last4', last4, 'expiry_date', expiry_date)
+		FROM flagged
+		RETURNING id`
+
+	rows, err := tx.QueryContext(ctx, query, withinDays, CardExpiryExpired, CardExpiryExpiring,
+		NotificationCardExpired, NotificationCardExpiring)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return 0, err
+	}
+	if err = enqueueNotificationIDs(ctx, tx, ids); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+func ValidateCreditCard(v *validator.Validator, card *CreditCard) {
+	v.Check(card.CardToken != "", "card_token", "must be provided")
+	v.Check(len(card.CardToken) <= 255, "card_token", "must not exceed 255 characters")
+	v.Check(!card.Expired(), "expiry_date", "card has expired")
+	v.Check(len(card.CardholderName) <= 255, "cardholder_name", "must not exceed 255 characters")
 }
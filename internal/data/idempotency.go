@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long a stored response is replayed , after that the key can be used again.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+const (
+	IdempotencyStatusInProgress = "in_progress"
+	IdempotencyStatusCompleted  = "completed"
+)
+
+// IdempotencyKey represents a client supplied Idempotency-Key and the response stored for it.
+type IdempotencyKey struct {
+	ID             int64
+	UserID         int64
+	Key            string
+	Fingerprint    string
+	Status         string
+	ResponseStatus int
+	ResponseBody   []byte
+	CreatedAt      time.Time
+}
+
+// IdempotencyModel wraps a sql.DB connection pool.
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Begin claims the key for a new request. It returns the stored record and false when the key was
+// already used , ErrIdempotencyKeyMismatch if that earlier request had a different fingerprint and
+// ErrIdempotencyKeyInProgress if it has not finished yet.
+func (m IdempotencyModel) Begin(ctx context.Context, userID int64, key, fingerprint string) (*IdempotencyKey, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// forget an expired key so it can be claimed again.
+	_, err := m.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2 AND created_at < $3`,
+		userID, key, time.Now().Add(-IdempotencyKeyTTL))
+	if err != nil {
+		return nil, false, err
+	}
+
+	record := &IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint, Status: IdempotencyStatusInProgress}
+
+	query := `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, request_fingerprint)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, idempotency_key) DO NOTHING
+		RETURNING id, created_at`
+	err = m.DB.QueryRowContext(ctx, query, userID, key, fingerprint).Scan(&record.ID, &record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	// the key already exists , load what was stored for it.
+	query = `
+		SELECT id, request_fingerprint, status, COALESCE(response_status, 0), response_body, created_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2`
+	err = m.DB.QueryRowContext(ctx, query, userID, key).
+		Scan(&record.ID, &record.Fingerprint, &record.Status, &record.ResponseStatus, &record.ResponseBody, &record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// released by the first request in the meantime.
+			return nil, false, ErrIdempotencyKeyInProgress
+		}
+		return nil, false, err
+	}
+
+	if record.Fingerprint != fingerprint {
+		return nil, false, ErrIdempotencyKeyMismatch
+	}
+	if record.Status != IdempotencyStatusCompleted {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	return record, false, nil
+}
+
+// Complete stores the response of the request that claimed the key.
+func (m IdempotencyModel) Complete(ctx context.Context, id int64, status int, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, response_status = $2, response_body = $3, completed_at = NOW()
+		WHERE id = $4`
+	_, err := m.DB.ExecContext(ctx, query, IdempotencyStatusCompleted, status, body, id)
+	return err
+}
+
+// Release deletes a claimed key whose request failed , so the client can retry with the same key.
+func (m IdempotencyModel) Release(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrEmailNotVerified = errors.New("the identity provider has not verified the email")
+
+// ExternalLogin is a sign in vouched for by an identity provider.
+type ExternalLogin struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// OAuthState is a sign in started at a provider and not yet back at the callback.
+type OAuthState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// IdentityModel wraps a sql.DB connection pool.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// SaveState stores a sign in attempt until the callback , expired attempts are cleared on the way.
+func (m IdentityModel) SaveState(ctx context.Context, s *OAuthState) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oauth_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO oauth_states (state, provider, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = m.DB.ExecContext(ctx, query, s.State, s.Provider, s.Nonce, s.CodeVerifier, s.ExpiresAt)
+	return err
+}
+
+// TakeState returns and deletes the sign in attempt , ErrRecordNotFound if it is unknown, used or expired.
+func (m IdentityModel) TakeState(ctx context.Context, state string) (*OAuthState, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM oauth_states
+		WHERE state = $1
+		RETURNING state, provider, nonce, code_verifier, expires_at`
+
+	var s OAuthState
+	err := m.DB.QueryRowContext(ctx, query, state).Scan(&s.State, &s.Provider, &s.Nonce, &s.CodeVerifier, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if time.Now().After(s.ExpiresAt) {
+		return nil, ErrRecordNotFound
+	}
+	return &s, nil
+}
+
+// Resolve returns the user an external sign in logs in as:
+//   - the user the identity is linked to ,
+//   - else the user with the same email , which the identity is linked to (the email must be verified) ,
+//   - else newUser , created and linked (the email must be verified).
+//
+// created reports whether newUser was inserted. ErrEmailNotVerified is returned when the identity is not
+// linked yet and the provider does not vouch for the email.
+func (m IdentityModel) Resolve(ctx context.Context, login ExternalLogin, newUser *User, actor Actor) (userID int64, created bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_identities
+		SET last_login_at = NOW(), email = $3
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id`
+	err = tx.QueryRowContext(ctx, query, login.Provider, login.Subject, login.Email).Scan(&userID)
+	switch {
+	case err == nil:
+		return userID, false, tx.Commit()
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, false, err
+	}
+
+	if !login.EmailVerified || login.Email == "" {
+		return 0, false, ErrEmailNotVerified
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE lower(email) = lower($1) ORDER BY id LIMIT 1 FOR UPDATE`, login.Email).
+		Scan(&userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if err = insertUser(ctx, tx, newUser); err != nil {
+			return 0, false, err
+		}
+		userID, created = newUser.ID, true
+
+		err = recordAudit(ctx, tx, actor, AuditUserSignedUp, userID, nil, nil, map[string]interface{}{
+			"email":    newUser.Email,
+			"role":     newUser.Role,
+			"provider": login.Provider,
+		})
+		if err != nil {
+			return 0, false, err
+		}
+	case err != nil:
+		return 0, false, err
+	}
+
+	query = `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, userID, login.Provider, login.Subject, login.Email)
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditIdentityLinked, userID, nil, nil, map[string]interface{}{
+		"provider": login.Provider,
+		"subject":  login.Subject,
+		"email":    login.Email,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return userID, created, nil
+}
@@ -0,0 +1,282 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"interviewTask/internal/validator"
+)
+
+// inventory movement reasons , a sale is negative and the others are usually positive
+// (an adjustment can go both ways).
+const (
+	MovementSale       = "sale"
+	MovementRestock    = "restock"
+	MovementReturn     = "return"
+	MovementAdjustment = "adjustment"
+)
+
+// InventoryMovement is one entry of the stock ledger , the ledger of a product sums up to its inventory_count.
+type InventoryMovement struct {
+	ID            int64     `json:"id"`
+	ProductID     int64     `json:"product_id"`
+	QuantityDelta int       `json:"quantity_delta"`
+	Reason        string    `json:"reason"`
+	ActorUserID   *int64    `json:"actor_user_id,omitempty"`
+	OrderID       *int64    `json:"order_id,omitempty"`
+	Note          string    `json:"note"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// InventoryBalance compares the stored stock of a product with its ledger.
+type InventoryBalance struct {
+	ProductID      int64 `json:"product_id"`
+	InventoryCount int   `json:"inventory_count"`
+	LedgerBalance  int   `json:"ledger_balance"`
+	Consistent     bool  `json:"consistent"`
+}
+
+// InventoryModel wraps a sql.DB connection pool.
+type InventoryModel struct {
+	DB *sql.DB
+}
+
+// Adjust applies a stock adjustment made by an admin and returns the recorded movement.
+func (m InventoryModel) Adjust(ctx context.Context, movement *InventoryMovement, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count, err := applyStockChange(ctx, tx, movement)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditProductStockAdjusted, movement.ProductID,
+		map[string]int{"inventory_count": count - movement.QuantityDelta},
+		map[string]int{"inventory_count": count},
+		map[string]interface{}{"movement_id": movement.ID, "reason": movement.Reason, "note": movement.Note})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMovements returns a page of the ledger of a product , newest first.
+func (m InventoryModel) GetMovements(ctx context.Context, productID int64, filters Filters) ([]*InventoryMovement, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, product_id, quantity_delta, reason, actor_user_id, order_id, note, created_at, count(*) OVER()
+		FROM inventory_movements
+		WHERE product_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movements := []*InventoryMovement{}
+	for rows.Next() {
+		var mv InventoryMovement
+		err = rows.Scan(&mv.ID, &mv.ProductID, &mv.QuantityDelta, &mv.Reason, &mv.ActorUserID, &mv.OrderID, &mv.Note,
+			&mv.CreatedAt, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movements = append(movements, &mv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movements, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetBalance reconciles the stored stock of a product against the sum of its ledger.
+func (m InventoryModel) GetBalance(ctx context.Context, productID int64) (*InventoryBalance, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT p.id, p.inventory_count, COALESCE(SUM(mv.quantity_delta), 0)
+		FROM products p
+		LEFT JOIN inventory_movements mv ON mv.product_id = p.id
+		WHERE p.id = $1
+		GROUP BY p.id, p.inventory_count`
+
+	var balance InventoryBalance
+	err := m.DB.QueryRowContext(ctx, query, productID).Scan(&balance.ProductID, &balance.InventoryCount, &balance.LedgerBalance)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	balance.Consistent = balance.InventoryCount == balance.LedgerBalance
+	return &balance, nil
+}
+
+// applyStockChange is the only place inventory_count changes , inside the caller's transaction it moves the
+// stock (never below zero), records the movement in the ledger and queues the back-in-stock and low-stock
+// notifications the change triggers. It returns the new stock.
+func applyStockChange(ctx context.Context, tx *sql.Tx, movement *InventoryMovement) (int, error) {
+	query := `
+		UPDATE products
+		SET inventory_count = inventory_count + $1, updated_at = NOW()
+		WHERE id = $2 AND inventory_count + $1 >= 0
+		RETURNING inventory_count, low_stock_threshold`
+
+	var newCount, threshold int
+	err := tx.QueryRowContext(ctx, query, movement.QuantityDelta, movement.ProductID).Scan(&newCount, &threshold)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM products WHERE id = $1)`, movement.ProductID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, ErrRecordNotFound
+		}
+		return 0, ErrInsufficientStock
+	}
+
+	err = recordMovement(ctx, tx, movement)
+	if err != nil {
+		return 0, err
+	}
+
+	previousCount := newCount - movement.QuantityDelta
+	err = queueWebhookEvent(ctx, tx, WebhookProductStockChanged, map[string]interface{}{
+		"product_id":      movement.ProductID,
+		"previous_count":  previousCount,
+		"inventory_count": newCount,
+		"delta":           movement.QuantityDelta,
+		"reason":          movement.Reason,
+		"order_id":        movement.OrderID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if previousCount == 0 && newCount > 0 {
+		err = queueBackInStockNotifications(ctx, tx, []int64{movement.ProductID})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if threshold > 0 && previousCount > threshold && newCount <= threshold {
+		err = queueLowStockAlerts(ctx, tx, movement.ProductID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return newCount, nil
+}
+
+// recordMovement inserts a ledger entry without touching the stock , for the rows written together with
+// inventory_count itself (a new product).
+func recordMovement(ctx context.Context, tx *sql.Tx, movement *InventoryMovement) error {
+	query := `
+		INSERT INTO inventory_movements (product_id, quantity_delta, reason, actor_user_id, order_id, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	return tx.QueryRowContext(ctx, query, movement.ProductID, movement.QuantityDelta, movement.Reason,
+		movement.ActorUserID, movement.OrderID, movement.Note).Scan(&movement.ID, &movement.CreatedAt)
+}
+
+// queueLowStockAlerts notifies every admin that the product fell to its low stock threshold.
+func queueLowStockAlerts(ctx context.Context, tx *sql.Tx, productID int64) error {
+	query := `
+		INSERT INTO notifications_outbox (user_id, kind, payload)
+		SELECT u.id, $2, jsonb_build_object('product_id', p.id, 'name', p.name,
+			'inventory_count', p.inventory_count, 'low_stock_threshold', p.low_stock_threshold)
+		FROM users u
+		CROSS JOIN products p
+		WHERE u.role = 'admin' AND p.id = $1
+		RETURNING id`
+	rows, err := tx.QueryContext(ctx, query, productID, NotificationLowStock)
+	if err != nil {
+		return err
+	}
+	return enqueueNotifications(ctx, tx, rows)
+}
+
+// restockOrder puts the products of an order back in stock , one return movement per line.
+func restockOrder(ctx context.Context, tx *sql.Tx, orderID int64, actorID *int64, note string) error {
+	return moveOrderStock(ctx, tx, orderID, actorID, MovementReturn, note)
+}
+
+// takeOrderStock takes the products of an order out of stock again , one sale movement per line. It fails with
+// ErrInsufficientStock when a line is no longer available.
+func takeOrderStock(ctx context.Context, tx *sql.Tx, orderID int64, actorID *int64, note string) error {
+	return moveOrderStock(ctx, tx, orderID, actorID, MovementSale, note)
+}
+
+// moveOrderStock applies the lines of an order to the stock , a sale takes them out and any other reason
+// puts them back.
+func moveOrderStock(ctx context.Context, tx *sql.Tx, orderID int64, actorID *int64, reason, note string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT product_id, quantity FROM order_products WHERE order_id = $1`, orderID)
+	if err != nil {
+		return err
+	}
+
+	var lines []OrderProduct
+	for rows.Next() {
+		var op OrderProduct
+		if err = rows.Scan(&op.ProductID, &op.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, op)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, op := range lines {
+		delta := op.Quantity
+		if reason == MovementSale {
+			delta = -delta
+		}
+		_, err = applyStockChange(ctx, tx, &InventoryMovement{
+			ProductID:     op.ProductID,
+			QuantityDelta: delta,
+			Reason:        reason,
+			ActorUserID:   actorID,
+			OrderID:       &orderID,
+			Note:          note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ValidateStockAdjustment(v *validator.Validator, movement *InventoryMovement) {
+	v.Check(movement.QuantityDelta != 0, "quantity_delta", "must not be zero")
+	v.Check(validator.In(movement.Reason, MovementRestock, MovementReturn, MovementAdjustment), "reason",
+		"must be one of restock, return or adjustment")
+	if movement.Reason == MovementRestock || movement.Reason == MovementReturn {
+		v.Check(movement.QuantityDelta > 0, "quantity_delta", "must be positive for a restock or return")
+	}
+	v.Check(len(movement.Note) <= 1000, "note", "must not exceed 1000 characters")
+}
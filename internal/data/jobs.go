@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"interviewTask/internal/jobs"
+	"interviewTask/internal/validator"
+)
+
+var ErrJobNotDead = errors.New("only dead jobs can be retried")
+var ErrJobAlreadyQueued = errors.New("a job with the same key is already queued")
+
+// Job is a row of the job queue as admins see it , the queue itself lives in the jobs package.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// JobFilters narrows down the job listing , empty fields match everything.
+type JobFilters struct {
+	Status string
+	Kind   string
+	Filters
+}
+
+// JobModel wraps a sql.DB connection pool.
+type JobModel struct {
+	DB *sql.DB
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, last_error, created_at, updated_at, finished_at`
+
+func scanJob(row rowScanner, j *Job, extra ...interface{}) error {
+	var payload []byte
+	dest := []interface{}{&j.ID, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt, &j.LastError,
+		&j.CreatedAt, &j.UpdatedAt, &j.FinishedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	j.Payload = payload
+	return nil
+}
+
+// GetAll returns a page of jobs matching the filters , newest first.
+func (m JobModel) GetAll(ctx context.Context, filters JobFilters) ([]*Job, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + jobColumns + `, count(*) OVER()
+		FROM jobs
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.Status, filters.Kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	list := []*Job{}
+	for rows.Next() {
+		var j Job
+		if err = scanJob(rows, &j, &totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+		list = append(list, &j)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return list, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Retry queues a dead job again with a fresh set of attempts.
+func (m JobModel) Retry(ctx context.Context, id int64, actor Actor) (*Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var old Job
+	err = scanJob(tx.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1 FOR UPDATE`, id), &old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if old.Status != jobs.StatusDead {
+		return nil, ErrJobNotDead
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, run_at = NOW(), finished_at = NULL, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + jobColumns
+	var j Job
+	err = scanJob(tx.QueryRowContext(ctx, query, id), &j)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrJobAlreadyQueued
+		}
+		return nil, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditJobRetried, id, nil, nil, map[string]interface{}{
+		"kind":       j.Kind,
+		"last_error": old.LastError,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func ValidateJobFilters(v *validator.Validator, f JobFilters) {
+	if f.Status != "" {
+		v.Check(validator.In(f.Status, jobs.Statuses...), "status", "invalid status")
+	}
+	v.Check(len(f.Kind) <= 100, "kind", "must not exceed 100 characters")
+	ValidateFilters(v, f.Filters)
+}
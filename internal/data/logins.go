@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginPolicy decides how failed logins slow down and lock out further attempts.
+type LoginPolicy struct {
+	Window        time.Duration // failures older than this are forgotten
+	DelayAfter    int           // failures of an account before every further attempt is delayed
+	BaseDelay     time.Duration // first delay , doubled with every further failure
+	MaxFailures   int           // failures of an account that lock it for Lockout
+	Lockout       time.Duration
+	IPMaxFailures int // failures from one IP (over all emails) that block it until the window moves on
+}
+
+// accountDelay is how long an account must wait after its last failure.
+func (p LoginPolicy) accountDelay(failures int) time.Duration {
+	switch {
+	case failures >= p.MaxFailures:
+		return p.Lockout
+	case failures >= p.DelayAfter:
+		delay := p.BaseDelay << (failures - p.DelayAfter)
+		if delay > p.Lockout || delay <= 0 {
+			return p.Lockout
+		}
+		return delay
+	}
+	return 0
+}
+
+// LoginAttemptModel wraps a sql.DB connection pool.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// LoginAttempt is an attempt let through by Begin , it counts as a failure from the start so that
+// concurrent guesses see it. RecordFailure keeps it , Forget drops it when the password or code was right.
+type LoginAttempt struct {
+	ID    int64
+	Email string
+	IP    string
+}
+
+// Begin checks whether the email or the IP has to wait and , if not , records the attempt in the same
+// transaction. The email and the IP stay locked until then so concurrent attempts go through one by one,
+// each seeing the ones before it. A nil attempt comes with how long to wait.
+func (m LoginAttemptModel) Begin(ctx context.Context, email, ip string, policy LoginPolicy) (*LoginAttempt, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	email = strings.ToLower(email)
+	window := policy.Window.Seconds()
+
+	// transaction level advisory locks , the keys are namespaced so they do not collide with other locks.
+	keys := []string{"email:" + email}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	for _, key := range keys {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('login_failures:' || $1))`, key)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// forget what is out of the window , the table stays as small as the window.
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE created_at <= clock_timestamp() - make_interval(secs => $1)`, window)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// clock_timestamp , not NOW() , since NOW() is when the transaction began and not when it got the locks.
+	query := `
+		SELECT
+			count(*) FILTER (WHERE email = $1),
+			COALESCE(max(created_at) FILTER (WHERE email = $1), clock_timestamp()),
+			count(*) FILTER (WHERE ip = NULLIF($2, '')::inet),
+			COALESCE(min(created_at) FILTER (WHERE ip = NULLIF($2, '')::inet), clock_timestamp()),
+			clock_timestamp()
+		FROM login_failures
+		WHERE created_at > clock_timestamp() - make_interval(secs => $3)
+		AND (email = $1 OR ip = NULLIF($2, '')::inet)`
+
+	var accountFailures, ipFailures int
+	var lastFailure, firstIPFailure, now time.Time
+	err = tx.QueryRowContext(ctx, query, email, ip, window).
+		Scan(&accountFailures, &lastFailure, &ipFailures, &firstIPFailure, &now)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	wait := lastFailure.Add(policy.accountDelay(accountFailures)).Sub(now)
+	if ipFailures >= policy.IPMaxFailures {
+		if ipWait := firstIPFailure.Add(policy.Window).Sub(now); ipWait > wait {
+			wait = ipWait
+		}
+	}
+	if wait > 0 {
+		return nil, wait, tx.Commit()
+	}
+
+	attempt := &LoginAttempt{Email: email, IP: ip}
+	query = `INSERT INTO login_failures (email, ip, created_at) VALUES ($1, NULLIF($2, '')::inet, clock_timestamp()) RETURNING id`
+	err = tx.QueryRowContext(ctx, query, email, ip).Scan(&attempt.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return attempt, 0, nil
+}
+
+// RecordFailure keeps the attempt as a failure and returns the failures of the email within the window
+// up to and including it. The failure that locks a real account (userID not 0) queues a notification to
+// its owner.
+func (m LoginAttemptModel) RecordFailure(ctx context.Context, attempt *LoginAttempt, userID int64, policy LoginPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// counting the failures up to this one , not all of them , makes exactly one attempt the locking one.
+	var failures int
+	query := `
+		SELECT count(*) FROM login_failures
+		WHERE email = $1 AND id <= $2 AND created_at > clock_timestamp() - make_interval(secs => $3)`
+	err = tx.QueryRowContext(ctx, query, attempt.Email, attempt.ID, policy.Window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	if failures == policy.MaxFailures && userID != 0 {
+		payload, err := json.Marshal(map[string]interface{}{
+			"ip":           attempt.IP,
+			"failures":     failures,
+			"locked_until": time.Now().Add(policy.Lockout).UTC(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		rows, err := tx.QueryContext(ctx, `INSERT INTO notifications_outbox (user_id, kind, payload) VALUES ($1, $2, $3) RETURNING id`,
+			userID, NotificationAccountLocked, string(payload))
+		if err != nil {
+			return 0, err
+		}
+		if err = enqueueNotifications(ctx, tx, rows); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// Forget drops an attempt that turned out right , the failures before it stay until Reset.
+func (m LoginAttemptModel) Forget(ctx context.Context, attempt *LoginAttempt) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE id = $1`, attempt.ID)
+	return err
+}
+
+// Reset forgets the failures of an email after a successful login.
+func (m LoginAttemptModel) Reset(ctx context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE email = $1`, strings.ToLower(email))
+	return err
+}
+
+// dummyHash is compared against when the email is unknown , so such a login costs the same bcrypt work
+// as a real one and the response time does not tell which emails are registered.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password for constant time logins"), bcrypt.DefaultCost)
+
+// DummyPasswordMatches burns the time of a password check , it never matches.
+func DummyPasswordMatches(plain string) bool {
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(plain))
+	return false
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+
+// RecoveryCodeCount is how many recovery codes a user gets , each works once.
+const RecoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP is the authenticator app enrolment of a user , it only protects logins once confirmed.
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+// Confirmed reports whether the user proved a code and the second factor is enforced.
+func (t *TOTP) Confirmed() bool {
+	return t.ConfirmedAt != nil
+}
+
+// MFAModel wraps a sql.DB connection pool.
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// GetTOTP returns the enrolment of the user , ErrRecordNotFound if they never started one.
+func (m MFAModel) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_step
+		FROM user_totp
+		WHERE user_id = $1`
+
+	var t TOTP
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&t.UserID, &t.Secret, &t.ConfirmedAt, &t.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Enroll stores a new unconfirmed secret for the user , replacing an earlier unconfirmed one.
+// It returns ErrMFAAlreadyEnabled when the user already has a confirmed secret.
+func (m MFAModel) Enroll(ctx context.Context, userID int64, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// Confirm turns the second factor on after the user proved the code of the given time step , and
+// returns a fresh set of recovery codes in plain text. They are not stored and can not be shown again.
+func (m MFAModel) Confirm(ctx context.Context, userID, step int64, actor Actor) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_totp
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL AND last_used_step < $2`
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditMFAEnabled, userID, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseStep accepts the code of a time step once , it reports false for the step of a code already
+// used (or an older one) so an intercepted code can not be replayed.
+func (m MFAModel) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes , it reports false for an unknown or used code.
+func (m MFAModel) UseRecoveryCode(ctx context.Context, userID int64, code string, actor Actor) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE id = (
+			SELECT id FROM mfa_recovery_codes
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+			FOR UPDATE
+		)
+		RETURNING (SELECT count(*) - 1 FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL)`
+
+	var remaining int
+	err = tx.QueryRowContext(ctx, query, userID, hashRecoveryCode(code)).Scan(&remaining)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditMFARecoveryCodeUsed, userID, nil, nil, map[string]interface{}{"remaining": remaining})
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RegenerateRecoveryCodes throws away the user's recovery codes and returns a new set in plain text.
+func (m MFAModel) RegenerateRecoveryCodes(ctx context.Context, userID int64, actor Actor) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditMFARecoveryCodesRegenerated, userID, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the user's secret and recovery codes , ErrRecordNotFound if they had none.
+func (m MFAModel) Disable(ctx context.Context, userID int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditMFADisabled, userID, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for RecoveryCodeCount new ones and returns them in plain text.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64) ([]string, error) {
+	_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a random code like "k3j9d-x7q2m" (50 bits).
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+// hashRecoveryCode hashes the code the way it is stored , dashes, spaces and case do not matter.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
@@ -7,19 +7,52 @@ import (
 
 var ErrRecordNotFound = errors.New("record not found")
 var ErrDuplicateEmail = errors.New("duplicate email")
+var ErrDuplicateSKU = errors.New("duplicate sku")
+var ErrInvalidOrderStatus = errors.New("order status does not allow this action")
+var ErrInsufficientStock = errors.New("insufficient stock")
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key reused with a different request")
+var ErrIdempotencyKeyInProgress = errors.New("idempotency key request still in progress")
 
 type Models struct {
-	Creditcard CreditCardModel
-	Users      UserModel
-	Product    ProductModel
-	Orders     OrdersModel
+	Creditcard    CreditCardModel
+	Users         UserModel
+	Product       ProductModel
+	Orders        OrdersModel
+	Idempotency   IdempotencyModel
+	Wishlist      WishlistModel
+	Reviews       ReviewModel
+	Inventory     InventoryModel
+	Reports       ReportModel
+	SalesRollup   SalesRollupModel
+	Audit         AuditModel
+	Logins        LoginAttemptModel
+	MFA           MFAModel
+	APIKeys       APIKeyModel
+	Identities    IdentityModel
+	Webhooks      WebhookModel
+	Jobs          JobModel
+	Notifications NotificationModel
 }
 
 func NewModel(db *sql.DB) Models {
 	return Models{
-		Creditcard: CreditCardModel{db},
-		Users:      UserModel{db},
-		Product:    ProductModel{db},
-		Orders:     OrdersModel{db},
+		Creditcard:    CreditCardModel{db},
+		Users:         UserModel{db},
+		Product:       ProductModel{db},
+		Orders:        OrdersModel{db},
+		Idempotency:   IdempotencyModel{db},
+		Wishlist:      WishlistModel{db},
+		Reviews:       ReviewModel{db},
+		Inventory:     InventoryModel{db},
+		Reports:       ReportModel{db},
+		SalesRollup:   SalesRollupModel{db},
+		Audit:         AuditModel{db},
+		Logins:        LoginAttemptModel{db},
+		MFA:           MFAModel{db},
+		APIKeys:       APIKeyModel{db},
+		Identities:    IdentityModel{db},
+		Webhooks:      WebhookModel{db},
+		Jobs:          JobModel{db},
+		Notifications: NotificationModel{db},
 	}
 }
@@ -0,0 +1,315 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"interviewTask/internal/jobs"
+	"interviewTask/internal/validator"
+)
+
+// notification kinds written to notifications_outbox , the payload is a JSON object
+// describing the subject of the notification.
+const (
+	NotificationCardExpiring    = "card_expiring"
+	NotificationCardExpired     = "card_expired"
+	NotificationBackInStock     = "back_in_stock"
+	NotificationLowStock        = "low_stock"
+	NotificationAccountLocked   = "account_locked"
+	NotificationOrderConfirmed  = "order_confirmed"
+	NotificationPaymentFailed   = "payment_failed"
+	NotificationOrderRefunded   = "order_refunded"
+	NotificationOrderShipped    = "order_shipped"
+	NotificationShippingUpdated = "shipping_updated"
+)
+
+var NotificationKinds = []string{
+	NotificationOrderConfirmed, NotificationPaymentFailed, NotificationOrderRefunded, NotificationOrderShipped,
+	NotificationShippingUpdated, NotificationCardExpiring, NotificationCardExpired, NotificationBackInStock,
+	NotificationLowStock, NotificationAccountLocked,
+}
+
+// mandatoryEmailKinds are always emailed , a locked account must reach its owner.
+var mandatoryEmailKinds = []string{NotificationAccountLocked}
+
+// JobSendNotification is the job kind that emails a notification.
+const JobSendNotification = "notification.send"
+
+// NotificationJob is the payload of a JobSendNotification job.
+type NotificationJob struct {
+	NotificationID int64 `json:"notification_id"`
+}
+
+// Notification is an entry of a user's inbox.
+type Notification struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Read      bool            `json:"read"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// NotificationPreference is the channels a user gets a kind of notification on.
+type NotificationPreference struct {
+	Kind  string `json:"kind"`
+	Email bool   `json:"email"`
+	InApp bool   `json:"in_app"`
+}
+
+// OutgoingEmail is a notification waiting to be emailed , with its recipient.
+type OutgoingEmail struct {
+	NotificationID int64
+	Kind           string
+	Payload        json.RawMessage
+	Email          string
+	FirstName      string
+}
+
+// NotificationModel wraps a sql.DB connection pool.
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// enqueueNotifications queues the email of every notification id in rows , it must run in the transaction
+// that wrote them. rows is consumed and closed.
+func enqueueNotifications(ctx context.Context, tx *sql.Tx, rows *sql.Rows) error {
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	return enqueueNotificationIDs(ctx, tx, ids)
+}
+
+func enqueueNotificationIDs(ctx context.Context, tx *sql.Tx, ids []int64) error {
+	for _, id := range ids {
+		_, err := jobs.Enqueue(ctx, tx, JobSendNotification, NotificationJob{NotificationID: id})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueOrderNotification notifies the owner of an order , the payload has the order and its lines.
+func queueOrderNotification(ctx context.Context, tx *sql.Tx, orderID int64, kind string) error {
+	query := `
+		INSERT INTO notifications_outbox (user_id, kind, payload)
+		SELECT o.user_id, $2, jsonb_build_object(
+			'order_id', o.id,
+			'status', o.status,
+			'total_amount', o.total_amount,
+			'tracking_number', COALESCE(o.tracking_number, ''),
+			'items', COALESCE((
+				SELECT jsonb_agg(jsonb_build_object('product_id', op.product_id, 'name', COALESCE(p.name, ''),
+					'quantity', op.quantity, 'price', op.price_at_purchase) ORDER BY op.product_id)
+				FROM order_products op
+				LEFT JOIN products p ON p.id = op.product_id
+				WHERE op.order_id = o.id
+			), '[]'::jsonb))
+		FROM orders o
+		WHERE o.id = $1
+		RETURNING id`
+	rows, err := tx.QueryContext(ctx, query, orderID, kind)
+	if err != nil {
+		return err
+	}
+	return enqueueNotifications(ctx, tx, rows)
+}
+
+// prefsJoin brings in the preference of the notification kind , a missing row means every channel.
+const prefsJoin = `
+	LEFT JOIN notification_preferences p ON p.user_id = n.user_id AND p.kind = n.kind`
+
+// GetForUser returns a page of the user's inbox , newest first , and the number of unread notifications.
+func (m NotificationModel) GetForUser(ctx context.Context, userID int64, unreadOnly bool, filters Filters) ([]*Notification, int, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var unread int
+	query := `SELECT count(*) FROM notifications_outbox n` + prefsJoin + `
+		WHERE n.user_id = $1 AND n.read_at IS NULL AND COALESCE(p.in_app, TRUE)`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&unread)
+	if err != nil {
+		return nil, 0, Metadata{}, err
+	}
+
+	query = `
+		SELECT n.id, n.kind, n.payload, n.read_at, n.created_at, count(*) OVER()
+		FROM notifications_outbox n` + prefsJoin + `
+		WHERE n.user_id = $1 AND COALESCE(p.in_app, TRUE) AND (NOT $2 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $3 OFFSET $4`
+	rows, err := m.DB.QueryContext(ctx, query, userID, unreadOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, 0, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	list := []*Notification{}
+	for rows.Next() {
+		var n Notification
+		var payload []byte
+		if err = rows.Scan(&n.ID, &n.Kind, &payload, &n.ReadAt, &n.CreatedAt, &totalRecords); err != nil {
+			return nil, 0, Metadata{}, err
+		}
+		n.Payload, n.Read = payload, n.ReadAt != nil
+		list = append(list, &n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, Metadata{}, err
+	}
+
+	return list, unread, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// SetRead marks one of the user's notifications read or unread again.
+func (m NotificationModel) SetRead(ctx context.Context, userID, id int64, read bool) (*Notification, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE notifications_outbox
+		SET read_at = CASE WHEN NOT $3 THEN NULL ELSE COALESCE(read_at, NOW()) END
+		WHERE id = $1 AND user_id = $2
+		RETURNING id, kind, payload, read_at, created_at`
+
+	var n Notification
+	var payload []byte
+	err := m.DB.QueryRowContext(ctx, query, id, userID, read).Scan(&n.ID, &n.Kind, &payload, &n.ReadAt, &n.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	n.Payload, n.Read = payload, n.ReadAt != nil
+	return &n, nil
+}
+
+// MarkAllRead marks the whole inbox read and returns the number of notifications that were unread.
+func (m NotificationModel) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE notifications_outbox SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPreferences returns the user's channels for every kind , the defaults (all channels) included.
+func (m NotificationModel) GetPreferences(ctx context.Context, userID int64) ([]NotificationPreference, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT kind, email, in_app FROM notification_preferences WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	saved := map[string]NotificationPreference{}
+	for rows.Next() {
+		var p NotificationPreference
+		if err = rows.Scan(&p.Kind, &p.Email, &p.InApp); err != nil {
+			return nil, err
+		}
+		saved[p.Kind] = p
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := make([]NotificationPreference, 0, len(NotificationKinds))
+	for _, kind := range NotificationKinds {
+		p, ok := saved[kind]
+		if !ok {
+			p = NotificationPreference{Kind: kind, Email: true, InApp: true}
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+// SetPreferences saves the channels of the given kinds , the other kinds keep theirs.
+func (m NotificationModel) SetPreferences(ctx context.Context, userID int64, prefs []NotificationPreference) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO notification_preferences (user_id, kind, email, in_app)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, kind) DO UPDATE
+		SET email = EXCLUDED.email, in_app = EXCLUDED.in_app, updated_at = NOW()`
+	for _, p := range prefs {
+		if _, err = tx.ExecContext(ctx, query, userID, p.Kind, p.Email, p.InApp); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PendingEmail returns a notification still to be emailed , ErrRecordNotFound when it was sent already or
+// its owner does not want this kind by email.
+func (m NotificationModel) PendingEmail(ctx context.Context, id int64) (*OutgoingEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT n.id, n.kind, n.payload, u.email, COALESCE(u.first_name, '')
+		FROM notifications_outbox n
+		JOIN users u ON u.id = n.user_id` + prefsJoin + `
+		WHERE n.id = $1 AND n.sent_at IS NULL AND (COALESCE(p.email, TRUE) OR n.kind = ANY($2))`
+
+	var e OutgoingEmail
+	var payload []byte
+	err := m.DB.QueryRowContext(ctx, query, id, pq.Array(mandatoryEmailKinds)).
+		Scan(&e.NotificationID, &e.Kind, &payload, &e.Email, &e.FirstName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	e.Payload = payload
+	return &e, nil
+}
+
+// MarkSent records that the notification was emailed.
+func (m NotificationModel) MarkSent(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE notifications_outbox SET sent_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func ValidateNotificationPreferences(v *validator.Validator, prefs []NotificationPreference) {
+	v.Check(len(prefs) > 0, "preferences", "must contain at least one preference")
+
+	seen := map[string]bool{}
+	for _, p := range prefs {
+		if !validator.In(p.Kind, NotificationKinds...) {
+			v.AddError("preferences", "invalid kind "+p.Kind)
+			continue
+		}
+		v.Check(!seen[p.Kind], "preferences", "duplicate kind "+p.Kind)
+		v.Check(p.Email || !validator.In(p.Kind, mandatoryEmailKinds...), "preferences", p.Kind+" is always emailed")
+		seen[p.Kind] = true
+	}
+}
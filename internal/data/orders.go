@@ -3,16 +3,40 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
+	"interviewTask/internal/validator"
+)
+
+// order statuses , an order starts as pending and stripe moves it to paid or failed,
+// admins then fulfil or cancel it.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusFailed    = "failed"
+	OrderStatusFulfilled = "fulfilled"
+	OrderStatusCancelled = "cancelled"
 )
 
+var OrderStatuses = []string{OrderStatusPending, OrderStatusPaid, OrderStatusFailed, OrderStatusFulfilled, OrderStatusCancelled}
+
 // Order represents an order placed by a user.
 type Order struct {
-	ID              int64     `json:"id"`
-	UserID          int64     `json:"user_id"`
-	TotalAmount     float64   `json:"total_amount"`
-	StripePaymentID string    `json:"stripe_payment_id"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"user_id"`
+	TotalAmount     float64    `json:"total_amount"`
+	StripePaymentID string     `json:"stripe_payment_id"`
+	CreditCardID    *int64     `json:"credit_card_id,omitempty"`
+	Status          string     `json:"status"`
+	TrackingNumber  string     `json:"tracking_number,omitempty"`
+	FulfilledAt     *time.Time `json:"fulfilled_at,omitempty"`
+	CancelledAt     *time.Time `json:"cancelled_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 // OrderProduct represents a record in the order_products table.
@@ -31,10 +55,22 @@ type PurchaseHistory struct {
 	UserID          int64                `json:"user_id"`
 	TotalAmount     float64              `json:"total_amount"`
 	StripePaymentID string               `json:"stripe_payment_id"`
+	Status          string               `json:"status"`
 	CreatedAt       time.Time            `json:"created_at"`
 	Products        []OrderProductDetail `json:"products"`
 }
 
+// HistoryFilters holds the options of the purchase history listing , Cursor is the opaque
+// next_cursor value of the previous page.
+type HistoryFilters struct {
+	From   time.Time
+	To     time.Time
+	Status string
+	Cursor string
+	Limit  int
+	after  *cursor
+}
+
 // OrderProductDetail works as a DTO ,
 type OrderProductDetail struct {
 	ProductID          int64     `json:"product_id"`
@@ -47,15 +83,39 @@ type OrderProductDetail struct {
 	ProductCreatedAt   time.Time `json:"product_created_at"`
 }
 
+// OrderDetail is the admin view of a single order , the order itself plus who placed it and what was bought.
+type OrderDetail struct {
+	Order
+	CustomerEmail string               `json:"customer_email"`
+	Products      []OrderProductDetail `json:"products"`
+}
+
+// OrderFilters holds the optional filters for the admin orders listing , zero values mean "not filtered".
+type OrderFilters struct {
+	Status    string
+	UserID    int64
+	From      time.Time
+	To        time.Time
+	MinAmount float64
+	MaxAmount float64
+	PaymentID string
+	Filters
+}
+
 type OrdersModel struct {
 	DB *sql.DB
 }
 
-// Create inserts a new order and its associated order_products records atomically.
-func (m OrdersModel) Create(order *Order, orderProducts []OrderProduct) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Create inserts a new order and its associated order_products records atomically. The order is pending
+// unless order.Status is paid , for a payment stripe confirmed right away.
+func (m OrdersModel) Create(ctx context.Context, order *Order, orderProducts []OrderProduct) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	if order.Status == "" {
+		order.Status = OrderStatusPending
+	}
+
 	// Begin a transaction.
 	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
@@ -64,11 +124,11 @@ func (m OrdersModel) Create(order *Order, orderProducts []OrderProduct) error {
 
 	// Insert the order record.
 	orderQuery := `
-		INSERT INTO orders (user_id, total_amount, stripe_payment_id)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at`
-	err = tx.QueryRowContext(ctx, orderQuery, order.UserID, order.TotalAmount, order.StripePaymentID).
-		Scan(&order.ID, &order.CreatedAt)
+		INSERT INTO orders (user_id, total_amount, stripe_payment_id, credit_card_id, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at`
+	err = tx.QueryRowContext(ctx, orderQuery, order.UserID, order.TotalAmount, order.StripePaymentID, order.CreditCardID, order.Status).
+		Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -79,133 +139,613 @@ func (m OrdersModel) Create(order *Order, orderProducts []OrderProduct) error {
 		INSERT INTO order_products (order_id, product_id, quantity, price_at_purchase)
 		VALUES ($1, $2, $3, $4)`
 
+	// Take the stock through the ledger , applyStockChange refuses to go below zero which makes
+	// concurrent purchases of the last items safe.
 	for _, op := range orderProducts {
 		_, err = tx.ExecContext(ctx, orderProductQuery, order.ID, op.ProductID, op.Quantity, op.PriceAtPurchase)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+
+		_, err = applyStockChange(ctx, tx, &InventoryMovement{
+			ProductID:     op.ProductID,
+			QuantityDelta: -op.Quantity,
+			Reason:        MovementSale,
+			ActorUserID:   &order.UserID,
+			OrderID:       &order.ID,
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// a paid order is a sale right away , as if the webhook had moved it from pending. A pending order is
+	// only confirmed to its owner once the payment goes through.
+	if order.Status == OrderStatusPaid {
+		if err = queueOrderNotification(ctx, tx, order.ID, NotificationOrderConfirmed); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = applyStatusChange(ctx, tx, order.ID, OrderStatusPending, OrderStatusPaid); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = queueWebhookEvent(ctx, tx, WebhookOrderPaid, orderEvent(order, OrderStatusPending)); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	return tx.Commit()
 }
 
-// GetPurchaseHistory retrieves all orders and their associated product details for a given user.
-// It uses a join query to combine orders, order_products, and products data.
-func (m OrdersModel) GetPurchaseHistory(userID int64) ([]PurchaseHistory, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// GetPurchaseHistory retrieves one page of a user's orders, newest first, with their product details.
+// Orders are paged with a keyset cursor on (created_at, id) so the sequence is stable between calls,
+// and the order lines are loaded with a second query bounded to the orders of the page.
+func (m OrdersModel) GetPurchaseHistory(ctx context.Context, userID int64, filters HistoryFilters) ([]PurchaseHistory, CursorMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	query := `
-	SELECT 
-		o.id, o.user_id, o.total_amount, o.stripe_payment_id, o.created_at,
-		op.product_id, op.quantity, op.price_at_purchase,
-		p.name, p.description, p.price, p.inventory_count, p.created_at as product_created_at
-	FROM orders o
-	JOIN order_products op ON o.id = op.order_id
-	JOIN products p ON op.product_id = p.id
-	WHERE o.user_id = $1
-	ORDER BY o.created_at DESC, op.product_id;
-	`
+	conditions := []string{"o.user_id = $1"}
+	args := []interface{}{userID}
+	addCondition := func(clause string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(clause, placeholders...))
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if filters.Status != "" {
+		addCondition("o.status = $%d", filters.Status)
+	}
+	if !filters.From.IsZero() {
+		addCondition("o.created_at >= $%d", filters.From)
+	}
+	if !filters.To.IsZero() {
+		addCondition("o.created_at < $%d", filters.To.AddDate(0, 0, 1))
+	}
+	if filters.after != nil {
+		addCondition("(o.created_at, o.id) < ($%d, $%d)", filters.after.CreatedAt, filters.after.ID)
+	}
+
+	// fetch one extra row to know whether there is a next page.
+	args = append(args, filters.Limit+1)
+	query := fmt.Sprintf(`
+		SELECT o.id, o.user_id, o.total_amount, o.stripe_payment_id, o.status, o.created_at
+		FROM orders o
+		WHERE %s
+		ORDER BY o.created_at DESC, o.id DESC
+		LIMIT $%d`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, CursorMetadata{}, err
 	}
 	defer rows.Close()
 
-	// Use a map to group products by order.
-	historyMap := make(map[int64]*PurchaseHistory)
-
+	history := []PurchaseHistory{}
 	for rows.Next() {
+		h := PurchaseHistory{Products: []OrderProductDetail{}}
+		err = rows.Scan(&h.OrderID, &h.UserID, &h.TotalAmount, &h.StripePaymentID, &h.Status, &h.CreatedAt)
+		if err != nil {
+			return nil, CursorMetadata{}, err
+		}
+		history = append(history, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, CursorMetadata{}, err
+	}
+
+	var metadata CursorMetadata
+	if len(history) > filters.Limit {
+		history = history[:filters.Limit]
+		last := history[len(history)-1]
+		metadata.NextCursor = encodeCursor(cursor{CreatedAt: last.CreatedAt, ID: last.OrderID})
+	}
+	if len(history) == 0 {
+		return history, metadata, nil
+	}
+
+	// Load the lines of this page only and attach them to their order (the slice keeps the order sequence).
+	orderIDs := make([]int64, len(history))
+	index := make(map[int64]int, len(history))
+	for i, h := range history {
+		orderIDs[i] = h.OrderID
+		index[h.OrderID] = i
+	}
+
+	linesQuery := `
+		SELECT op.order_id, op.product_id, op.quantity, op.price_at_purchase,
+			p.name, p.description, p.price, p.inventory_count, p.created_at
+		FROM order_products op
+		JOIN products p ON p.id = op.product_id
+		WHERE op.order_id = ANY($1)
+		ORDER BY op.order_id, op.product_id`
+
+	lines, err := m.DB.QueryContext(ctx, linesQuery, pq.Array(orderIDs))
+	if err != nil {
+		return nil, CursorMetadata{}, err
+	}
+	defer lines.Close()
+
+	for lines.Next() {
 		var orderID int64
-		var userID int64
-		var totalAmount float64
-		var stripePaymentID string
-		var orderCreatedAt time.Time
-
-		var productID int64
-		var quantity int
-		var priceAtPurchase float64
-		var productName string
-		var productDescription string
-		var productPrice float64
-		var inventoryCount int
-		var productCreatedAt time.Time
-
-		err = rows.Scan(
-			&orderID, &userID, &totalAmount, &stripePaymentID, &orderCreatedAt,
-			&productID, &quantity, &priceAtPurchase,
-			&productName, &productDescription, &productPrice, &inventoryCount, &productCreatedAt,
-		)
+		var d OrderProductDetail
+		err = lines.Scan(&orderID, &d.ProductID, &d.Quantity, &d.PriceAtPurchase,
+			&d.ProductName, &d.ProductDescription, &d.ProductPrice, &d.InventoryCount, &d.ProductCreatedAt)
 		if err != nil {
-			return nil, err
+			return nil, CursorMetadata{}, err
 		}
+		i := index[orderID]
+		history[i].Products = append(history[i].Products, d)
+	}
+	if err = lines.Err(); err != nil {
+		return nil, CursorMetadata{}, err
+	}
+
+	return history, metadata, nil
+}
+
+// stripeTransitions are the statuses a stripe event may move an order from , a replayed or late event must
+// not bring back a cancelled (refunded) or fulfilled order.
+var stripeTransitions = map[string][]string{
+	OrderStatusPaid:   {OrderStatusPending, OrderStatusFailed},
+	OrderStatusFailed: {OrderStatusPending},
+}
+
+// UpdateStatusByStripePaymentID updates the status of an order based on its Stripe PaymentIntent ID ,
+// orders moving in or out of the paid statuses are applied to the sales rollups in the same transaction.
+// Orders that can not move to status from where they are are left alone , that is not an error. A failed
+// order gives its stock back and takes it again if it gets paid after all , ErrInsufficientStock when it
+// is gone by then.
+func (m OrdersModel) UpdateStatusByStripePaymentID(ctx context.Context, paymentIntentID, status string) error {
+	allowed, ok := stripeTransitions[status]
+	if !ok {
+		return ErrInvalidOrderStatus
+	}
 
-		// Check if we've already created a PurchaseHistory for this order.
-		history, exists := historyMap[orderID]
-		if !exists {
-			history = &PurchaseHistory{
-				OrderID:         orderID,
-				UserID:          userID,
-				TotalAmount:     totalAmount,
-				StripePaymentID: stripePaymentID,
-				CreatedAt:       orderCreatedAt,
-				Products:        []OrderProductDetail{},
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the "old" subquery locks the orders and still sees their status from before the update.
+	query := `
+		UPDATE orders o
+		SET status = $1, updated_at = NOW()
+		FROM (SELECT id, status FROM orders WHERE stripe_payment_id = $2 AND status = ANY($3) FOR UPDATE) old
+		WHERE o.id = old.id
+		RETURNING o.id, old.status
+	`
+	rows, err := tx.QueryContext(ctx, query, status, paymentIntentID, pq.Array(allowed))
+	if err != nil {
+		return err
+	}
+	previous := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var from string
+		if err = rows.Scan(&id, &from); err != nil {
+			rows.Close()
+			return err
+		}
+		previous[id] = from
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, from := range previous {
+		if err = applyStatusChange(ctx, tx, id, from, status); err != nil {
+			return err
+		}
+
+		switch status {
+		case OrderStatusPaid:
+			// the stock of a failed order went back , a payment that succeeds after all takes it again.
+			if from == OrderStatusFailed {
+				if err = takeOrderStock(ctx, tx, id, nil, "payment succeeded after failing"); err != nil {
+					return err
+				}
+			}
+			o, err := lockOrder(ctx, tx, id, 0)
+			if err != nil {
+				return err
+			}
+			if err = queueWebhookEvent(ctx, tx, WebhookOrderPaid, orderEvent(o, from)); err != nil {
+				return err
+			}
+			if err = queueOrderNotification(ctx, tx, id, NotificationOrderConfirmed); err != nil {
+				return err
+			}
+		case OrderStatusFailed:
+			// a declined or abandoned payment must not hold the stock.
+			if err = restockOrder(ctx, tx, id, nil, "payment failed"); err != nil {
+				return err
+			}
+			if err = queueOrderNotification(ctx, tx, id, NotificationPaymentFailed); err != nil {
+				return err
 			}
-			historyMap[orderID] = history
 		}
+	}
+
+	return tx.Commit()
+}
 
-		// Append product details.
-		productDetail := OrderProductDetail{
-			ProductID:          productID,
-			Quantity:           quantity,
-			PriceAtPurchase:    priceAtPurchase,
-			ProductName:        productName,
-			ProductDescription: productDescription,
-			ProductPrice:       productPrice,
-			InventoryCount:     inventoryCount,
-			ProductCreatedAt:   productCreatedAt,
+// orderColumns is the select list scanned by scanOrder , keep both in sync.
+const orderColumns = `
+	o.id, o.user_id, o.total_amount, o.stripe_payment_id, o.credit_card_id, o.status,
+	COALESCE(o.tracking_number, ''), o.fulfilled_at, o.cancelled_at, o.created_at, o.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row rowScanner, o *Order, extra ...interface{}) error {
+	dest := []interface{}{
+		&o.ID, &o.UserID, &o.TotalAmount, &o.StripePaymentID, &o.CreditCardID, &o.Status,
+		&o.TrackingNumber, &o.FulfilledAt, &o.CancelledAt, &o.CreatedAt, &o.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// GetAll returns a page of orders matching the given filters, newest first by default.
+func (m OrdersModel) GetAll(ctx context.Context, filters OrderFilters) ([]*Order, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Build the where clause dynamically , every filter adds its own placeholder.
+	var conditions []string
+	var args []interface{}
+	addCondition := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filters.Status != "" {
+		addCondition("o.status = $%d", filters.Status)
+	}
+	if filters.UserID != 0 {
+		addCondition("o.user_id = $%d", filters.UserID)
+	}
+	if !filters.From.IsZero() {
+		addCondition("o.created_at >= $%d", filters.From)
+	}
+	if !filters.To.IsZero() {
+		// "to" is a calendar day , include all of it.
+		addCondition("o.created_at < $%d", filters.To.AddDate(0, 0, 1))
+	}
+	if filters.MinAmount > 0 {
+		addCondition("o.total_amount >= $%d", filters.MinAmount)
+	}
+	if filters.MaxAmount > 0 {
+		addCondition("o.total_amount <= $%d", filters.MaxAmount)
+	}
+	if filters.PaymentID != "" {
+		addCondition("o.stripe_payment_id = $%d", filters.PaymentID)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, count(*) OVER()
+		FROM orders o
+		%s
+		ORDER BY o.%s %s, o.id DESC
+		LIMIT $%d OFFSET $%d`,
+		orderColumns, where, filters.sortColumn(), filters.sortDirection(), len(args)+1, len(args)+2)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []*Order{}
+	for rows.Next() {
+		var o Order
+		if err = scanOrder(rows, &o, &totalRecords); err != nil {
+			return nil, Metadata{}, err
 		}
-		history.Products = append(history.Products, productDetail)
-	} // loop ends here .
+		orders = append(orders, &o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return orders, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
 
+// GetDetails returns a single order with the customer email and the purchased products.
+func (m OrdersModel) GetDetails(ctx context.Context, id int64) (*OrderDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + orderColumns + `, u.email
+		FROM orders o
+		JOIN users u ON u.id = o.user_id
+		WHERE o.id = $1`
+
+	var detail OrderDetail
+	err := scanOrder(m.DB.QueryRowContext(ctx, query, id), &detail.Order, &detail.CustomerEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	detail.Products, err = m.getOrderProducts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// getOrderProducts loads the order lines of one order joined with the current product data.
+func (m OrdersModel) getOrderProducts(ctx context.Context, orderID int64) ([]OrderProductDetail, error) {
+	query := `
+		SELECT op.product_id, op.quantity, op.price_at_purchase,
+			p.name, p.description, p.price, p.inventory_count, p.created_at
+		FROM order_products op
+		JOIN products p ON p.id = op.product_id
+		WHERE op.order_id = $1
+		ORDER BY op.product_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []OrderProductDetail{}
+	for rows.Next() {
+		var d OrderProductDetail
+		err = rows.Scan(&d.ProductID, &d.Quantity, &d.PriceAtPurchase,
+			&d.ProductName, &d.ProductDescription, &d.ProductPrice, &d.InventoryCount, &d.ProductCreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, d)
+	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	return products, nil
+}
+
+// MarkFulfilled moves a paid order to fulfilled.
+func (m OrdersModel) MarkFulfilled(ctx context.Context, id int64, actor Actor) (*Order, error) {
+	query := `
+		UPDATE orders o
+		SET status = 'fulfilled', fulfilled_at = NOW(), updated_at = NOW()
+		WHERE o.id = $1 AND o.status = ANY($2)
+		RETURNING ` + orderColumns
+	return m.transition(ctx, id, actor, AuditOrderFulfilled, NotificationOrderShipped, query, []string{OrderStatusPaid})
+}
+
+// SetTrackingNumber attaches a shipment tracking number to a paid or fulfilled order.
+func (m OrdersModel) SetTrackingNumber(ctx context.Context, id int64, trackingNumber string, actor Actor) (*Order, error) {
+	query := `
+		UPDATE orders o
+		SET tracking_number = $3, updated_at = NOW()
+		WHERE o.id = $1 AND o.status = ANY($2)
+		RETURNING ` + orderColumns
+	return m.transition(ctx, id, actor, AuditOrderTrackingUpdated, NotificationShippingUpdated, query,
+		[]string{OrderStatusPaid, OrderStatusFulfilled}, trackingNumber)
+}
 
-	// Convert the map to a slice.
-	result := make([]PurchaseHistory, 0, len(historyMap))
-	for _, h := range historyMap {
-		result = append(result, *h)
+// Get retrieves a single order by its ID.
+func (m OrdersModel) Get(ctx context.Context, id int64) (*Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + orderColumns + ` FROM orders o WHERE o.id = $1`
+
+	var o Order
+	err := scanOrder(m.DB.QueryRowContext(ctx, query, id), &o)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
 	}
+	return &o, nil
+}
+
+// CancellableStatuses are the statuses an order can be cancelled from , anything later has already shipped.
+var CancellableStatuses = []string{OrderStatusPending, OrderStatusPaid}
 
-	return result, nil
+// Cancel cancels an order that has not been fulfilled yet and puts its products back in stock ,
+// the actor is the admin doing it.
+func (m OrdersModel) Cancel(ctx context.Context, id int64, actor Actor) (*Order, error) {
+	return m.cancel(ctx, id, 0, actor)
 }
 
-// UpdateStatusByStripePaymentID updates the status of an order based on its Stripe PaymentIntent ID.
-func (m OrdersModel) UpdateStatusByStripePaymentID(paymentIntentID, status string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// CancelForUser is the same as Cancel but only matches orders owned by the acting user.
+func (m OrdersModel) CancelForUser(ctx context.Context, id int64, actor Actor) (*Order, error) {
+	return m.cancel(ctx, id, actor.UserID, actor)
+}
+
+// cancel does the status change and the restock in one transaction , a zero userID means any owner.
+func (m OrdersModel) cancel(ctx context.Context, id, userID int64, actor Actor) (*Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := lockOrder(ctx, tx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
-		UPDATE orders
-		SET status = $1, updated_at = NOW()
-		WHERE stripe_payment_id = $2
-	`
-	result, err := m.DB.ExecContext(ctx, query, status, paymentIntentID)
+		UPDATE orders o
+		SET status = 'cancelled', cancelled_at = NOW(), updated_at = NOW()
+		WHERE o.id = $1 AND o.status = ANY($2)
+		RETURNING ` + orderColumns
+
+	var o Order
+	err = scanOrder(tx.QueryRowContext(ctx, query, id, pq.Array(CancellableStatuses)), &o)
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidOrderStatus
+		}
+		return nil, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = restockOrder(ctx, tx, id, &actor.UserID, "order cancelled")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// a paid order that is cancelled was refunded , it no longer counts as a sale.
+	err = applyStatusChange(ctx, tx, id, old.Status, o.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditOrderCancelled, id, old, &o, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queueWebhookEvent(ctx, tx, WebhookOrderCancelled, orderEvent(&o, old.Status))
+	if err != nil {
+		return nil, err
+	}
+	if old.Status == OrderStatusPaid {
+		err = queueWebhookEvent(ctx, tx, WebhookOrderRefunded, orderEvent(&o, old.Status))
+		if err != nil {
+			return nil, err
+		}
+		err = queueOrderNotification(ctx, tx, id, NotificationOrderRefunded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// transition runs a guarded update on one order , audits it and notifies the owner with the given kind.
+// The query must take the order id as $1 and the allowed current statuses as $2.
+func (m OrdersModel) transition(ctx context.Context, id int64, actor Actor, action, notification, query string, from []string, args ...interface{}) (*Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := lockOrder(ctx, tx, id, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var o Order
+	args = append([]interface{}{id, pq.Array(from)}, args...)
+	err = scanOrder(tx.QueryRowContext(ctx, query, args...), &o)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidOrderStatus
+		}
+		return nil, err
+	}
+
+	err = recordAudit(ctx, tx, actor, action, id, old, &o, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queueOrderNotification(ctx, tx, id, notification)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// lockOrder reads an order for update , a zero userID means any owner.
+func lockOrder(ctx context.Context, tx *sql.Tx, id, userID int64) (*Order, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders o WHERE o.id = $1 AND ($2::bigint = 0 OR o.user_id = $2) FOR UPDATE`
+
+	var o Order
+	err := scanOrder(tx.QueryRowContext(ctx, query, id, userID), &o)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &o, nil
+}
+
+func ValidateOrderFilters(v *validator.Validator, f OrderFilters) {
+	if f.Status != "" {
+		v.Check(validator.In(f.Status, OrderStatuses...), "status", "invalid order status")
+	}
+	v.Check(f.UserID >= 0, "user_id", "must be a positive value")
+	v.Check(f.MinAmount >= 0, "min_amount", "must be a non-negative value")
+	v.Check(f.MaxAmount >= 0, "max_amount", "must be a non-negative value")
+	if f.MaxAmount > 0 {
+		v.Check(f.MinAmount <= f.MaxAmount, "max_amount", "must not be less than min_amount")
 	}
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(!f.To.Before(f.From), "to", "must not be before from")
+	}
+	ValidateFilters(v, f.Filters)
+}
+
+// ValidateHistoryFilters checks the filters and decodes the cursor , so it must run before GetPurchaseHistory.
+func ValidateHistoryFilters(v *validator.Validator, f *HistoryFilters) {
+	if f.Status != "" {
+		v.Check(validator.In(f.Status, OrderStatuses...), "status", "invalid order status")
+	}
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(!f.To.Before(f.From), "to", "must not be before from")
+	}
+	v.Check(f.Limit > 0, "limit", "must be greater than zero")
+	v.Check(f.Limit <= 100, "limit", "must be a maximum of 100")
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+			return
+		}
+		f.after = &c
 	}
+}
 
-	return nil
+func ValidateTrackingNumber(v *validator.Validator, trackingNumber string) {
+	v.Check(trackingNumber != "", "tracking_number", "must be provided")
+	v.Check(len(trackingNumber) <= 255, "tracking_number", "must not exceed 255 characters")
 }
@@ -3,20 +3,39 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"interviewTask/internal/validator"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // Product represents a product in the catalog.
 type Product struct {
-	ID             int64     `json:"id"`
-	Name           string    `json:"name"`
-	Description    string    `json:"description"`
-	Price          float64   `json:"price"`
-	InventoryCount int       `json:"inventory_count"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID                int64     `json:"id"`
+	SKU               string    `json:"sku,omitempty"`
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	Price             float64   `json:"price"`
+	InventoryCount    int       `json:"inventory_count"`
+	LowStockThreshold int       `json:"low_stock_threshold"`
+	AverageRating     float64   `json:"average_rating"`
+	RatingCount       int       `json:"rating_count"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// productColumns is the select list scanned by scanProduct , keep both in sync.
+const productColumns = `
+	id, COALESCE(sku, ''), name, description, price, inventory_count, low_stock_threshold,
+	CASE WHEN rating_count > 0 THEN ROUND(rating_sum::numeric / rating_count, 2) ELSE 0 END, rating_count,
+	created_at, updated_at`
+
+func scanProduct(row rowScanner, p *Product) error {
+	return row.Scan(&p.ID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.InventoryCount, &p.LowStockThreshold, &p.AverageRating, &p.RatingCount,
+		&p.CreatedAt, &p.UpdatedAt)
 }
 
 // ProductSale represents aggregated sales information for a product.
@@ -33,12 +52,12 @@ type ProductModel struct {
 }
 
 // GetAll retrieves all products.
-func (m ProductModel) GetAll() ([]Product, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m ProductModel) GetAll(ctx context.Context) ([]Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT id, name, description, price, inventory_count, created_at, updated_at
+		SELECT ` + productColumns + `
 		FROM products
 		ORDER BY created_at`
 	rows, err := m.DB.QueryContext(ctx, query)
@@ -50,7 +69,7 @@ func (m ProductModel) GetAll() ([]Product, error) {
 	var products []Product
 	for rows.Next() {
 		var p Product
-		err = rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.InventoryCount, &p.CreatedAt, &p.UpdatedAt)
+		err = scanProduct(rows, &p)
 		if err != nil {
 			return nil, err
 		}
@@ -63,17 +82,16 @@ func (m ProductModel) GetAll() ([]Product, error) {
 }
 
 // GetByID retrieves a single product by its ID.
-func (m ProductModel) GetByID(id int64) (*Product, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m ProductModel) GetByID(ctx context.Context, id int64) (*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT id, name, description, price, inventory_count, created_at, updated_at
+		SELECT ` + productColumns + `
 		FROM products
 		WHERE id = $1`
 	var p Product
-	err := m.DB.QueryRowContext(ctx, query, id).
-		Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.InventoryCount, &p.CreatedAt, &p.UpdatedAt)
+	err := scanProduct(m.DB.QueryRowContext(ctx, query, id), &p)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrRecordNotFound
@@ -83,71 +101,355 @@ func (m ProductModel) GetByID(id int64) (*Product, error) {
 	return &p, nil
 }
 
-// Create inserts a new product into the database.
-func (m ProductModel) Create(p *Product) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Create inserts a new product into the database , its initial stock is the first entry of the ledger.
+func (m ProductModel) Create(ctx context.Context, p *Product, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = createProduct(ctx, tx, p, actor, "initial stock")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update modifies an existing product.
+// stock is the new inventory count or nil to leave it alone , a change is applied as a manual adjustment
+// in the ledger , made by the actor.
+func (m ProductModel) Update(ctx context.Context, p *Product, stock *int, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateProduct(ctx, tx, p, stock, actor, "product update")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createProduct(ctx context.Context, tx *sql.Tx, p *Product, actor Actor, note string) error {
 	query := `
-		INSERT INTO products (name, description, price, inventory_count, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		INSERT INTO products (sku, name, description, price, inventory_count, low_stock_threshold, created_at, updated_at)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, NOW(), NOW())
 		RETURNING id, created_at, updated_at`
-	return m.DB.QueryRowContext(ctx, query, p.Name, p.Description, p.Price, p.InventoryCount).
+	err := tx.QueryRowContext(ctx, query, p.SKU, p.Name, p.Description, p.Price, p.InventoryCount, p.LowStockThreshold).
 		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return productWriteError(err)
+	}
+
+	if p.InventoryCount > 0 {
+		err = recordMovement(ctx, tx, &InventoryMovement{
+			ProductID:     p.ID,
+			QuantityDelta: p.InventoryCount,
+			Reason:        MovementRestock,
+			ActorUserID:   &actor.UserID,
+			Note:          note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return recordAudit(ctx, tx, actor, AuditProductCreated, p.ID, nil, p, nil)
 }
 
-// Update modifies an existing product.
-func (m ProductModel) Update(p *Product) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// updateProduct writes p over the locked row , the stock only changes when stock is not nil and the delta
+// is taken from the locked row so sales committed in the meantime are kept.
+func updateProduct(ctx context.Context, tx *sql.Tx, p *Product, stock *int, actor Actor, note string) error {
+	var old Product
+	err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productColumns+` FROM products WHERE id = $1 FOR UPDATE`, p.ID), &old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
 
 	query := `
 		UPDATE products
-		SET name = $1, description = $2, price = $3, inventory_count = $4, updated_at = NOW()
-		WHERE id = $5
+		SET sku = NULLIF($1, ''), name = $2, description = $3, price = $4, low_stock_threshold = $5, updated_at = NOW()
+		WHERE id = $6
 		RETURNING updated_at`
-	return m.DB.QueryRowContext(ctx, query, p.Name, p.Description, p.Price, p.InventoryCount, p.ID).
-		Scan(&p.UpdatedAt)
+	err = tx.QueryRowContext(ctx, query, p.SKU, p.Name, p.Description, p.Price, p.LowStockThreshold, p.ID).Scan(&p.UpdatedAt)
+	if err != nil {
+		return productWriteError(err)
+	}
+	// fields an update does not touch.
+	p.CreatedAt, p.AverageRating, p.RatingCount = old.CreatedAt, old.AverageRating, old.RatingCount
+
+	p.InventoryCount = old.InventoryCount
+	if stock != nil && *stock != old.InventoryCount {
+		p.InventoryCount, err = applyStockChange(ctx, tx, &InventoryMovement{
+			ProductID:     p.ID,
+			QuantityDelta: *stock - old.InventoryCount,
+			Reason:        MovementAdjustment,
+			ActorUserID:   &actor.UserID,
+			Note:          note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Price != old.Price {
+		err = queueWebhookEvent(ctx, tx, WebhookProductPriceChanged, map[string]interface{}{
+			"product_id":     p.ID,
+			"sku":            p.SKU,
+			"name":           p.Name,
+			"previous_price": old.Price,
+			"price":          p.Price,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return recordAudit(ctx, tx, actor, AuditProductUpdated, p.ID, &old, p, nil)
+}
+
+// productWriteError maps the unique violation on products.sku (PostgreSQL error code 23505) to ErrDuplicateSKU.
+func productWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateSKU
+	}
+	return err
 }
 
-// Delete removes a product from the database.
-func (m ProductModel) Delete(id int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// ImportResult counts what a catalog import did (or would do , for a dry run).
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+// ImportRowError is an import row that could not be matched or written , Row is 1-based.
+type ImportRowError struct {
+	Row     int
+	Message string
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// Import upserts the products in one transaction , a row is matched by SKU when it has one and by
+// case-insensitive name otherwise. Stock changes go through the ledger. A dry run does all the work
+// and rolls it back, so it reports exactly what a real run would do.
+func (m ProductModel) Import(ctx context.Context, products []*Product, actor Actor, dryRun bool) (*ImportResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	query := `DELETE FROM products WHERE id = $1`
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{}
+	for i, p := range products {
+		var ids []int64
+		var rows *sql.Rows
+		if p.SKU != "" {
+			rows, err = tx.QueryContext(ctx, `SELECT id FROM products WHERE sku = $1`, p.SKU)
+		} else {
+			rows, err = tx.QueryContext(ctx, `SELECT id FROM products WHERE lower(name) = lower($1)`, p.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+
+		switch len(ids) {
+		case 0:
+			err = createProduct(ctx, tx, p, actor, "catalog import")
+			result.Created++
+		case 1:
+			p.ID = ids[0]
+			quantity := p.InventoryCount
+			err = updateProduct(ctx, tx, p, &quantity, actor, "catalog import")
+			result.Updated++
+		default:
+			return nil, &ImportRowError{Row: i + 1, Message: "name matches more than one product, add a sku"}
+		}
+		if err != nil {
+			if errors.Is(err, ErrDuplicateSKU) {
+				return nil, &ImportRowError{Row: i + 1, Message: "sku already used by another product"}
+			}
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// SalesFiltering retrieves product sales data filtered by a time period or username.
-// Note: This method assumes you have an orders and order_products table with relevant fields.
-func (m ProductModel) SalesFiltering(from, to time.Time, username string) ([]ProductSale, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Export streams the whole catalog to fn one product at a time , ordered by id ,
+// so the catalog is never held in memory.
+func (m ProductModel) Export(ctx context.Context, fn func(*Product) error) error {
+	query := `SELECT ` + productColumns + ` FROM products ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var p Product
+	for rows.Next() {
+		if err = scanProduct(rows, &p); err != nil {
+			return err
+		}
+		if err = fn(&p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Delete removes a product from the database , the audit event keeps a copy of it.
+func (m ProductModel) Delete(ctx context.Context, id int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// Build the query dynamically based on provided filters.
-	query := `
-	SELECT 
-		p.id,
-		p.name,
-		COALESCE(SUM(op.quantity), 0) AS total_quantity,
-		COALESCE(SUM(op.quantity * op.price_at_purchase), 0) AS total_revenue
-	FROM products p
-	LEFT JOIN order_products op ON p.id = op.product_id
-	LEFT JOIN orders o ON op.order_id = o.id
-	LEFT JOIN users u ON o.user_id = u.id
-	WHERE o.created_at BETWEEN $1 AND $2`
-	args := []interface{}{from, to}
-
-	if username != "" {
-		username = validator.SanitizeString(username)
-		query += ` AND u.first_name ILIKE $3`
-		args = append(args, fmt.Sprintf("%%%s%%", username)) // %%%% to match any string contnais the username
-		// used with Ilike operator , Ilike perform case-insensitive pattern match
-	}
-
-	query += ` GROUP BY p.id, p.name ORDER BY total_revenue DESC`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM products WHERE id = $1 RETURNING ` + productColumns
+	var p Product
+	err = scanProduct(tx.QueryRowContext(ctx, query, id), &p)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditProductDeleted, id, &p, nil, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SalesFilters narrows down the per-product sales report.
+// The period is the half-open range [From, To) , the handler turns calendar days in the
+// requested timezone into these instants.
+type SalesFilters struct {
+	From        time.Time
+	To          time.Time
+	Statuses    []string // RevenueStatuses when empty
+	IncludeZero bool     // also list products that sold nothing in the period
+	Username    string   // fuzzy first name match
+	UserID      int64
+	Email       string
+}
+
+// SalesFiltering retrieves per-product sales over the filters' period , best sellers first.
+// Order filters live in the joined subquery rather than the WHERE clause so the LEFT JOIN
+// keeps unsold products when IncludeZero is set. Closed days are read from the daily_product_sales
+// rollup whenever the filters allow it , only today is computed from the orders.
+func (m ProductModel) SalesFiltering(ctx context.Context, filters SalesFilters) ([]ProductSale, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	statuses := filters.Statuses
+	if len(statuses) == 0 {
+		statuses = RevenueStatuses
+	}
+
+	var args []interface{}
+	var sources []string
+
+	// closed days come from the rollup when nothing narrows the orders down further than it does.
+	split := periodSplit{live: true, liveFrom: filters.From, liveTo: filters.To}
+	if isRevenueStatuses(statuses) && filters.Username == "" && filters.UserID == 0 && filters.Email == "" {
+		split = splitPeriod(filters.From, filters.To)
+	}
+	if split.rollup {
+		args = append(args, split.rollupFrom.Format(time.DateOnly), split.rollupTo.Format(time.DateOnly))
+		sources = append(sources, `
+			SELECT product_id, units AS quantity, revenue
+			FROM daily_product_sales
+			WHERE day >= $1::date AND day < $2::date`)
+	}
+
+	if split.live {
+		var conditions []string
+		addCondition := func(clause string, arg interface{}) {
+			args = append(args, arg)
+			conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+		}
+
+		addCondition("o.created_at >= $%d", split.liveFrom)
+		addCondition("o.created_at < $%d", split.liveTo)
+		addCondition("o.status = ANY($%d)", pq.Array(statuses))
+		if filters.Username != "" {
+			// ILIKE performs a case-insensitive pattern match , %% matches any string containing the username.
+			addCondition("u.first_name ILIKE $%d", fmt.Sprintf("%%%s%%", validator.SanitizeString(filters.Username)))
+		}
+		if filters.UserID != 0 {
+			addCondition("o.user_id = $%d", filters.UserID)
+		}
+		if filters.Email != "" {
+			addCondition("lower(u.email) = lower($%d)", filters.Email)
+		}
+
+		sources = append(sources, `
+			SELECT op.product_id, op.quantity, op.quantity * op.price_at_purchase AS revenue
+			FROM order_products op
+			JOIN orders o ON o.id = op.order_id
+			JOIN users u ON u.id = o.user_id
+			WHERE `+strings.Join(conditions, " AND "))
+	}
+
+	having := ""
+	if !filters.IncludeZero {
+		// rollup rows of refunded sales stay behind with zero units.
+		having = "HAVING COALESCE(SUM(s.quantity), 0) > 0"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			p.id,
+			p.name,
+			COALESCE(SUM(s.quantity), 0) AS total_quantity,
+			COALESCE(SUM(s.revenue), 0) AS total_revenue
+		FROM products p
+		LEFT JOIN (%s
+		) s ON s.product_id = p.id
+		GROUP BY p.id, p.name
+		%s
+		ORDER BY total_revenue DESC, p.id`, strings.Join(sources, "\n\t\t\tUNION ALL"), having)
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -155,7 +457,7 @@ func (m ProductModel) SalesFiltering(from, to time.Time, username string) ([]Pro
 	}
 	defer rows.Close()
 
-	var sales []ProductSale
+	sales := []ProductSale{}
 	for rows.Next() {
 		var ps ProductSale
 		err = rows.Scan(&ps.ProductID, &ps.Name, &ps.TotalQuantity, &ps.TotalRevenue)
@@ -171,11 +473,26 @@ func (m ProductModel) SalesFiltering(from, to time.Time, username string) ([]Pro
 	return sales, nil
 }
 
+func ValidateSalesFilters(v *validator.Validator, f SalesFilters) {
+	v.Check(!f.From.IsZero(), "from", "must be provided")
+	v.Check(!f.To.IsZero(), "to", "must be provided")
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(f.To.After(f.From), "to", "must not be before from")
+	}
+	for _, status := range f.Statuses {
+		v.Check(validator.In(status, OrderStatuses...), "status", "invalid order status")
+	}
+	v.Check(f.UserID >= 0, "user_id", "must be a positive value")
+	v.Check(len(f.Email) <= 255, "email", "must not exceed 255 characters")
+}
+
 func ValidateProduct(v *validator.Validator, product *Product) {
+	v.Check(len(product.SKU) <= 64, "sku", "must not exceed 64 characters")
 	v.Check(product.Name != "", "name", "must be provided")
 	v.Check(len(product.Name) <= 255, "name", "must not exceed 255 characters")
 	v.Check(product.Description != "", "description", "must be provided")
 	v.Check(len(product.Description) > 40 && len(product.Description) < 1200, "description", "description must be between 40 and 1200 character long")
 	v.Check(product.Price > 0, "price", "must be a positive value")
 	v.Check(product.InventoryCount >= 0, "inventory_count", "must be a non-negative value")
+	v.Check(product.LowStockThreshold >= 0, "low_stock_threshold", "must be a non-negative value")
 }
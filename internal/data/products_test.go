@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"interviewTask/internal/testdb"
+	"interviewTask/internal/validator"
+)
+
+// salesFixture is what seedSales wrote , the ids the expectations refer to.
+type salesFixture struct {
+	widget, gadget, unsold int64
+}
+
+// seedSales writes two customers , three products and orders around the 2024-03-02 day boundary in UTC and
+// in New York (UTC-5 that day) , then rebuilds the rollups the way cmd/backfill does.
+func seedSales(t *testing.T, db *sql.DB, today time.Time) salesFixture {
+	t.Helper()
+	ctx := context.Background()
+
+	user := func(email, firstName string) int64 {
+		var id int64
+		err := db.QueryRowContext(ctx, `INSERT INTO users (email, password_hash, first_name) VALUES ($1, 'x', $2) RETURNING id`,
+			email, firstName).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	product := func(name string, price float64) int64 {
+		var id int64
+		err := db.QueryRowContext(ctx, `INSERT INTO products (name, price, inventory_count) VALUES ($1, $2, 100) RETURNING id`,
+			name, price).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	order := func(userID int64, status string, createdAt time.Time, productID int64, quantity int, price float64) {
+		var id int64
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO orders (user_id, total_amount, stripe_payment_id, status, created_at)
+			VALUES ($1, $2, 'pi_test', $3, $4)
+			RETURNING id`, userID, float64(quantity)*price, status, createdAt).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.ExecContext(ctx, `INSERT INTO order_products (order_id, product_id, quantity, price_at_purchase) VALUES ($1, $2, $3, $4)`,
+			id, productID, quantity, price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	alice := user("alice@example.com", "Alice")
+	bob := user("bob@example.com", "Bob")
+
+	f := salesFixture{
+		widget: product("Widget", 10),
+		gadget: product("Gadget", 20),
+		unsold: product("Unsold", 5),
+	}
+
+	order(alice, OrderStatusPaid, at("2024-03-01T10:00:00Z"), f.widget, 2, 10)
+	order(bob, OrderStatusFulfilled, at("2024-03-02T23:30:00Z"), f.gadget, 1, 20) // last half hour of the UTC day
+	order(alice, OrderStatusPaid, at("2024-03-03T00:30:00Z"), f.widget, 1, 10)    // still 2024-03-02 in New York
+	order(bob, OrderStatusPending, at("2024-03-02T12:00:00Z"), f.gadget, 3, 20)
+	order(alice, OrderStatusFailed, at("2024-03-02T13:00:00Z"), f.widget, 5, 10)
+	order(bob, OrderStatusPaid, today.Add(time.Second), f.gadget, 2, 20)
+
+	_, err := SalesRollupModel{DB: db}.Rebuild(ctx, at("2024-03-01T00:00:00Z"), today.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSalesFiltering(t *testing.T) {
+	db := testdb.New(t)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	f := seedSales(t, db, today)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// days reads the calendar days from and to (both included) in location , as the handler does.
+	days := func(from, to string, location *time.Location) (time.Time, time.Time) {
+		start, err := time.ParseInLocation(time.DateOnly, from, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := time.ParseInLocation(time.DateOnly, to, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return start, end.AddDate(0, 0, 1)
+	}
+	withDays := func(from, to string, location *time.Location, filters SalesFilters) SalesFilters {
+		filters.From, filters.To = days(from, to, location)
+		return filters
+	}
+
+	tests := []struct {
+		name    string
+		filters SalesFilters
+		want    []ProductSale
+	}{
+		{
+			name:    "the to day is included",
+			filters: withDays("2024-03-01", "2024-03-02", time.UTC, SalesFilters{}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 2, TotalRevenue: 20},
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 1, TotalRevenue: 20},
+			},
+		},
+		{
+			name:    "a single day up to its last minute",
+			filters: withDays("2024-03-02", "2024-03-02", time.UTC, SalesFilters{}),
+			want: []ProductSale{
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 1, TotalRevenue: 20},
+			},
+		},
+		{
+			name:    "the day after to is left out",
+			filters: withDays("2024-03-03", "2024-03-03", time.UTC, SalesFilters{}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 1, TotalRevenue: 10},
+			},
+		},
+		{
+			name:    "days in the tz timezone",
+			filters: withDays("2024-03-02", "2024-03-02", newYork, SalesFilters{}),
+			want: []ProductSale{
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 1, TotalRevenue: 20},
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 1, TotalRevenue: 10},
+			},
+		},
+		{
+			name:    "pending and failed orders are not sales by default",
+			filters: withDays("2024-03-01", "2024-03-03", time.UTC, SalesFilters{}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 3, TotalRevenue: 30},
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 1, TotalRevenue: 20},
+			},
+		},
+		{
+			name:    "status whitelist",
+			filters: withDays("2024-03-01", "2024-03-03", time.UTC, SalesFilters{Statuses: []string{OrderStatusPending}}),
+			want: []ProductSale{
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 3, TotalRevenue: 60},
+			},
+		},
+		{
+			name: "several statuses",
+			filters: withDays("2024-03-01", "2024-03-03", time.UTC,
+				SalesFilters{Statuses: []string{OrderStatusPaid, OrderStatusFailed}}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 8, TotalRevenue: 80},
+			},
+		},
+		{
+			name:    "include_zero lists the unsold products",
+			filters: withDays("2024-03-01", "2024-03-02", time.UTC, SalesFilters{IncludeZero: true}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 2, TotalRevenue: 20},
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 1, TotalRevenue: 20},
+				{ProductID: f.unsold, Name: "Unsold", TotalQuantity: 0, TotalRevenue: 0},
+			},
+		},
+		{
+			name:    "include_zero with nothing sold",
+			filters: withDays("2024-02-01", "2024-02-29", time.UTC, SalesFilters{IncludeZero: true}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget"},
+				{ProductID: f.gadget, Name: "Gadget"},
+				{ProductID: f.unsold, Name: "Unsold"},
+			},
+		},
+		{
+			name:    "customer email",
+			filters: withDays("2024-03-01", "2024-03-03", time.UTC, SalesFilters{Email: "ALICE@example.com"}),
+			want: []ProductSale{
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 3, TotalRevenue: 30},
+			},
+		},
+		{
+			name:    "today is read from the orders",
+			filters: SalesFilters{From: today.AddDate(0, 0, -1), To: today.AddDate(0, 0, 1)},
+			want: []ProductSale{
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 2, TotalRevenue: 40},
+			},
+		},
+		{
+			name:    "closed days and today together",
+			filters: SalesFilters{From: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), To: today.AddDate(0, 0, 1)},
+			want: []ProductSale{
+				{ProductID: f.gadget, Name: "Gadget", TotalQuantity: 3, TotalRevenue: 60},
+				{ProductID: f.widget, Name: "Widget", TotalQuantity: 3, TotalRevenue: 30},
+			},
+		},
+	}
+
+	m := ProductModel{DB: db}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.SalesFiltering(context.Background(), tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSalesFilters(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	tests := []struct {
+		name    string
+		filters SalesFilters
+		errors  []string
+	}{
+		{name: "valid", filters: SalesFilters{From: from, To: to, Statuses: []string{OrderStatusPaid, OrderStatusPending}}},
+		{name: "missing period", filters: SalesFilters{}, errors: []string{"from", "to"}},
+		{name: "to before from", filters: SalesFilters{From: to, To: from}, errors: []string{"to"}},
+		{name: "a single day", filters: SalesFilters{From: from, To: from}, errors: []string{"to"}},
+		{name: "unknown status", filters: SalesFilters{From: from, To: to, Statuses: []string{"paid", "shipped"}}, errors: []string{"status"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateSalesFilters(v, tt.filters)
+			if len(v.Errors) != len(tt.errors) {
+				t.Fatalf("got errors %v , want %v", v.Errors, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.Errors[key]; !ok {
+					t.Errorf("no error for %q in %v", key, v.Errors)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"interviewTask/internal/validator"
+)
+
+// ReportIntervals are the buckets a revenue time-series can be grouped by , they are passed to date_trunc.
+var ReportIntervals = []string{"day", "week", "month"}
+
+// RevenueStatuses are the order statuses counted as revenue when a report is not given any.
+var RevenueStatuses = []string{OrderStatusPaid, OrderStatusFulfilled}
+
+// ReportFilters narrows down the orders a report is computed over.
+type ReportFilters struct {
+	From     time.Time // first calendar day , zero means no lower bound
+	To       time.Time // last calendar day (included) , zero means no upper bound
+	Interval string
+	Statuses []string
+	UserID   int64
+	Email    string
+	Limit    int
+}
+
+// RevenuePoint is one bucket of the revenue time-series.
+type RevenuePoint struct {
+	Period  time.Time `json:"period"`
+	Orders  int       `json:"orders"`
+	Units   int       `json:"units"`
+	Revenue float64   `json:"revenue"`
+}
+
+// CustomerTotal is what a single customer spent over the report period.
+type CustomerTotal struct {
+	UserID            int64   `json:"user_id"`
+	Email             string  `json:"email"`
+	FirstName         string  `json:"first_name"`
+	LastName          string  `json:"last_name"`
+	Orders            int     `json:"orders"`
+	Revenue           float64 `json:"revenue"`
+	AverageOrderValue float64 `json:"average_order_value"`
+}
+
+// SalesSummary holds the headline figures of the report period.
+type SalesSummary struct {
+	Orders            int     `json:"orders"`
+	Units             int     `json:"units"`
+	Revenue           float64 `json:"revenue"`
+	AverageOrderValue float64 `json:"average_order_value"`
+}
+
+// ReportModel wraps a sql.DB connection pool , it only reads.
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// period returns the report period as the half-open range [from, to) , a zero time is an open bound.
+func (f ReportFilters) period() (time.Time, time.Time) {
+	to := f.To
+	if !to.IsZero() {
+		// "to" is a calendar day , include all of it.
+		to = to.AddDate(0, 0, 1)
+	}
+	return f.From, to
+}
+
+// statuses returns the order statuses counted by the report.
+func (f ReportFilters) statuses() []string {
+	if len(f.Statuses) == 0 {
+		return RevenueStatuses
+	}
+	return f.Statuses
+}
+
+// rollupSplit cuts the report period for the daily_sales rollup , or reads it all live when the
+// filters go further than the rollup does.
+func (f ReportFilters) rollupSplit() periodSplit {
+	from, to := f.period()
+	if !isRevenueStatuses(f.statuses()) || f.UserID != 0 || f.Email != "" {
+		return periodSplit{live: true, liveFrom: from, liveTo: to}
+	}
+	return splitPeriod(from, to)
+}
+
+// where builds the WHERE clause over orders o joined with users u for the orders placed in [from, to) ,
+// every filter adds its own placeholder.
+func (f ReportFilters) where(args []interface{}, from, to time.Time) (string, []interface{}) {
+	var conditions []string
+	addCondition := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	addCondition("o.status = ANY($%d)", pq.Array(f.statuses()))
+	if !from.IsZero() {
+		addCondition("o.created_at >= $%d", from)
+	}
+	if !to.IsZero() {
+		addCondition("o.created_at < $%d", to)
+	}
+	if f.UserID != 0 {
+		addCondition("o.user_id = $%d", f.UserID)
+	}
+	if f.Email != "" {
+		addCondition("lower(u.email) = lower($%d)", f.Email)
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// dailySales builds the rows (day, orders, units, revenue) the revenue and summary reports aggregate over ,
+// closed days from the daily_sales rollup when the filters allow it and the rest from the orders.
+// Days are UTC.
+func (f ReportFilters) dailySales(args []interface{}) (string, []interface{}) {
+	split := f.rollupSplit()
+
+	var sources []string
+	if split.rollup {
+		var conditions []string
+		if !split.rollupFrom.IsZero() {
+			args = append(args, split.rollupFrom.Format(time.DateOnly))
+			conditions = append(conditions, fmt.Sprintf("day >= $%d::date", len(args)))
+		}
+		args = append(args, split.rollupTo.Format(time.DateOnly))
+		conditions = append(conditions, fmt.Sprintf("day < $%d::date", len(args)))
+
+		sources = append(sources, `
+			SELECT day::timestamp AS day, orders, units, revenue
+			FROM daily_sales
+			WHERE `+strings.Join(conditions, " AND "))
+	}
+
+	if split.live {
+		var where string
+		where, args = f.where(args, split.liveFrom, split.liveTo)
+		sources = append(sources, `
+			SELECT o.created_at AT TIME ZONE 'UTC' AS day, 1 AS orders, COALESCE(units.quantity, 0) AS units, o.total_amount AS revenue
+			FROM orders o
+			JOIN users u ON u.id = o.user_id
+			LEFT JOIN LATERAL (
+				SELECT SUM(op.quantity) AS quantity FROM order_products op WHERE op.order_id = o.id
+			) units ON TRUE
+			`+where)
+	}
+
+	return strings.Join(sources, "\n\t\t\tUNION ALL"), args
+}
+
+// Revenue returns revenue, orders and units sold bucketed by the filters' interval (in UTC) , oldest bucket first.
+// Buckets without any order are left out.
+func (m ReportModel) Revenue(ctx context.Context, filters ReportFilters) ([]*RevenuePoint, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	source, args := filters.dailySales([]interface{}{filters.Interval})
+	query := fmt.Sprintf(`
+		SELECT date_trunc($1, s.day) AS period, SUM(s.orders), SUM(s.units), SUM(s.revenue)
+		FROM (%s
+		) s
+		GROUP BY period
+		HAVING SUM(s.orders) > 0
+		ORDER BY period`, source)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*RevenuePoint{}
+	for rows.Next() {
+		var p RevenuePoint
+		if err = rows.Scan(&p.Period, &p.Orders, &p.Units, &p.Revenue); err != nil {
+			return nil, err
+		}
+		points = append(points, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// TopCustomers returns the customers who spent the most over the report period , at most filters.Limit of them.
+func (m ReportModel) TopCustomers(ctx context.Context, filters ReportFilters) ([]*CustomerTotal, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	from, to := filters.period()
+	where, args := filters.where(nil, from, to)
+	query := fmt.Sprintf(`
+		SELECT u.id, u.email, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+			count(*), SUM(o.total_amount), AVG(o.total_amount)
+		FROM orders o
+		JOIN users u ON u.id = o.user_id
+		%s
+		GROUP BY u.id, u.email, u.first_name, u.last_name
+		ORDER BY SUM(o.total_amount) DESC, u.id
+		LIMIT $%d`, where, len(args)+1)
+	args = append(args, filters.Limit)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []*CustomerTotal{}
+	for rows.Next() {
+		var c CustomerTotal
+		err = rows.Scan(&c.UserID, &c.Email, &c.FirstName, &c.LastName, &c.Orders, &c.Revenue, &c.AverageOrderValue)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return customers, nil
+}
+
+// Summary returns the order count, units, revenue and average order value over the report period.
+func (m ReportModel) Summary(ctx context.Context, filters ReportFilters) (*SalesSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	source, args := filters.dailySales(nil)
+	query := fmt.Sprintf(`
+		SELECT COALESCE(SUM(s.orders), 0), COALESCE(SUM(s.units), 0), COALESCE(SUM(s.revenue), 0),
+			COALESCE(SUM(s.revenue) / NULLIF(SUM(s.orders), 0), 0)
+		FROM (%s
+		) s`, source)
+
+	var s SalesSummary
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&s.Orders, &s.Units, &s.Revenue, &s.AverageOrderValue)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func ValidateReportFilters(v *validator.Validator, f ReportFilters) {
+	v.Check(validator.In(f.Interval, ReportIntervals...), "interval", "must be day, week or month")
+	for _, status := range f.Statuses {
+		v.Check(validator.In(status, OrderStatuses...), "status", "invalid order status")
+	}
+	v.Check(f.UserID >= 0, "user_id", "must be a positive value")
+	v.Check(len(f.Email) <= 255, "email", "must not exceed 255 characters")
+	v.Check(f.Limit > 0 && f.Limit <= 100, "limit", "must be between 1 and 100")
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(!f.To.Before(f.From), "to", "must not be before from")
+	}
+}
@@ -0,0 +1,275 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"interviewTask/internal/validator"
+)
+
+// review statuses , only published reviews are listed and counted in the product rating.
+const (
+	ReviewStatusPublished = "published"
+	ReviewStatusHidden    = "hidden"
+)
+
+// Review is a rating and text left on a product by a user who bought it.
+type Review struct {
+	ID         int64     `json:"id"`
+	ProductID  int64     `json:"product_id"`
+	UserID     int64     `json:"user_id"`
+	AuthorName string    `json:"author_name"`
+	Rating     int       `json:"rating"`
+	Body       string    `json:"body"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ReviewModel wraps a sql.DB connection pool.
+// Every write keeps products.rating_sum and products.rating_count in step with the published
+// reviews in the same transaction , so reads never have to aggregate the reviews.
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+const reviewColumns = `
+	r.id, r.product_id, r.user_id, COALESCE(u.first_name, ''), r.rating, r.body, r.status, r.created_at, r.updated_at`
+
+func scanReview(row rowScanner, review *Review, extra ...interface{}) error {
+	dest := []interface{}{&review.ID, &review.ProductID, &review.UserID, &review.AuthorName, &review.Rating,
+		&review.Body, &review.Status, &review.CreatedAt, &review.UpdatedAt}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// HasPurchased reports whether the user has a paid or fulfilled order containing the product.
+func (m ReviewModel) HasPurchased(ctx context.Context, userID, productID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM order_products op
+			JOIN orders o ON o.id = op.order_id
+			WHERE o.user_id = $1 AND op.product_id = $2 AND o.status IN ($3, $4)
+		)`
+	var purchased bool
+	err := m.DB.QueryRowContext(ctx, query, userID, productID, OrderStatusPaid, OrderStatusFulfilled).Scan(&purchased)
+	return purchased, err
+}
+
+// Upsert creates the user's review of a product or replaces their previous one.
+func (m ReviewModel) Upsert(ctx context.Context, review *Review) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the product , this serialises concurrent reviews of it so the running totals stay exact.
+	err = tx.QueryRowContext(ctx, `SELECT id FROM products WHERE id = $1 FOR UPDATE`, review.ProductID).Scan(&review.ProductID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	var previousRating int
+	var previousStatus string
+	err = tx.QueryRowContext(ctx, `SELECT rating, status FROM product_reviews WHERE product_id = $1 AND user_id = $2`,
+		review.ProductID, review.UserID).Scan(&previousRating, &previousStatus)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	exists := err == nil
+
+	query := `
+		INSERT INTO product_reviews (product_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (product_id, user_id) DO UPDATE SET rating = EXCLUDED.rating, body = EXCLUDED.body, updated_at = NOW()
+		RETURNING id, status, created_at, updated_at`
+	err = tx.QueryRowContext(ctx, query, review.ProductID, review.UserID, review.Rating, review.Body).
+		Scan(&review.ID, &review.Status, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !exists:
+		err = adjustProductRating(ctx, tx, review.ProductID, review.Rating, 1)
+	case previousStatus == ReviewStatusPublished:
+		err = adjustProductRating(ctx, tx, review.ProductID, review.Rating-previousRating, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForProduct returns a page of the published reviews of a product , newest first.
+func (m ReviewModel) GetAllForProduct(ctx context.Context, productID int64, filters Filters) ([]*Review, Metadata, error) {
+	return m.list(ctx, productID, ReviewStatusPublished, filters)
+}
+
+// GetAll returns a page of all reviews for moderation , optionally narrowed to a product and/or status.
+func (m ReviewModel) GetAll(ctx context.Context, productID int64, status string, filters Filters) ([]*Review, Metadata, error) {
+	return m.list(ctx, productID, status, filters)
+}
+
+func (m ReviewModel) list(ctx context.Context, productID int64, status string, filters Filters) ([]*Review, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		SELECT %s, count(*) OVER()
+		FROM product_reviews r
+		JOIN users u ON u.id = r.user_id
+		WHERE ($1::bigint = 0 OR r.product_id = $1)
+		AND ($2 = '' OR r.status = $2)
+		ORDER BY r.%s %s, r.id DESC
+		LIMIT $3 OFFSET $4`, reviewColumns, filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, productID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+		if err = scanReview(rows, &review, &totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// SetStatus publishes or hides a review , moving its rating in or out of the product totals.
+func (m ReviewModel) SetStatus(ctx context.Context, id int64, status string, actor Actor) (*Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previousStatus string
+	err = tx.QueryRowContext(ctx, `
+		SELECT r.status FROM product_reviews r
+		JOIN products p ON p.id = r.product_id
+		WHERE r.id = $1
+		FOR UPDATE`, id).Scan(&previousStatus)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	query := `
+		UPDATE product_reviews r
+		SET status = $2, updated_at = NOW()
+		FROM users u
+		WHERE r.id = $1 AND u.id = r.user_id
+		RETURNING ` + reviewColumns
+	var review Review
+	err = scanReview(tx.QueryRowContext(ctx, query, id, status), &review)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case previousStatus != ReviewStatusPublished && status == ReviewStatusPublished:
+		err = adjustProductRating(ctx, tx, review.ProductID, review.Rating, 1)
+	case previousStatus == ReviewStatusPublished && status != ReviewStatusPublished:
+		err = adjustProductRating(ctx, tx, review.ProductID, -review.Rating, -1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditReviewModerated, id,
+		map[string]string{"status": previousStatus}, map[string]string{"status": status}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// Delete removes a review and takes it out of the product totals if it was published.
+func (m ReviewModel) Delete(ctx context.Context, id int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var productID, userID int64
+	var rating int
+	var status, body string
+	err = tx.QueryRowContext(ctx, `DELETE FROM product_reviews WHERE id = $1 RETURNING product_id, user_id, rating, status, body`, id).
+		Scan(&productID, &userID, &rating, &status, &body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if status == ReviewStatusPublished {
+		err = adjustProductRating(ctx, tx, productID, -rating, -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	before := map[string]interface{}{"product_id": productID, "user_id": userID, "rating": rating, "status": status, "body": body}
+	err = recordAudit(ctx, tx, actor, AuditReviewDeleted, id, before, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// adjustProductRating moves the running rating totals of a product.
+func adjustProductRating(ctx context.Context, tx *sql.Tx, productID int64, sumDelta, countDelta int) error {
+	if sumDelta == 0 && countDelta == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE products
+		SET rating_sum = rating_sum + $1, rating_count = rating_count + $2
+		WHERE id = $3`, sumDelta, countDelta, productID)
+	return err
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Body) <= 2000, "body", "must not exceed 2000 characters")
+}
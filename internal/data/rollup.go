@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// the sales rollups hold per day (UTC) totals of the orders in RevenueStatuses. Every change of an order
+// status in or out of those statuses applies the order to the rollups in the same transaction , so closed
+// days can be read from the rollups and only today from the live tables.
+
+// countsAsSale reports whether an order in this status is part of the rollups.
+func countsAsSale(status string) bool {
+	for _, s := range RevenueStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// isRevenueStatuses reports whether the statuses are exactly RevenueStatuses , the only set the rollups can answer.
+func isRevenueStatuses(statuses []string) bool {
+	seen := map[string]bool{}
+	for _, s := range statuses {
+		if !countsAsSale(s) {
+			return false
+		}
+		seen[s] = true
+	}
+	return len(seen) == len(RevenueStatuses)
+}
+
+// applySalesRollup adds the order to the rollups of the day it was placed (sign 1) or takes it out (sign -1).
+func applySalesRollup(ctx context.Context, tx *sql.Tx, orderID int64, sign int) error {
+	query := `
+		INSERT INTO daily_product_sales AS d (day, product_id, units, revenue)
+		SELECT (o.created_at AT TIME ZONE 'UTC')::date, op.product_id,
+			$2::int * SUM(op.quantity), $2::int * SUM(op.quantity * op.price_at_purchase)
+		FROM orders o
+		JOIN order_products op ON op.order_id = o.id
+		WHERE o.id = $1
+		GROUP BY 1, op.product_id
+		ON CONFLICT (day, product_id) DO UPDATE
+		SET units = d.units + EXCLUDED.units, revenue = d.revenue + EXCLUDED.revenue`
+	_, err := tx.ExecContext(ctx, query, orderID, sign)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO daily_sales AS d (day, orders, units, revenue)
+		SELECT (o.created_at AT TIME ZONE 'UTC')::date, $2::int,
+			$2::int * COALESCE((SELECT SUM(op.quantity) FROM order_products op WHERE op.order_id = o.id), 0),
+			$2::int * o.total_amount
+		FROM orders o
+		WHERE o.id = $1
+		ON CONFLICT (day) DO UPDATE
+		SET orders = d.orders + EXCLUDED.orders, units = d.units + EXCLUDED.units, revenue = d.revenue + EXCLUDED.revenue`
+	_, err = tx.ExecContext(ctx, query, orderID, sign)
+	return err
+}
+
+// applyStatusChange keeps the rollups in line with an order going from one status to another.
+func applyStatusChange(ctx context.Context, tx *sql.Tx, orderID int64, from, to string) error {
+	switch {
+	case !countsAsSale(from) && countsAsSale(to):
+		return applySalesRollup(ctx, tx, orderID, 1)
+	case countsAsSale(from) && !countsAsSale(to):
+		return applySalesRollup(ctx, tx, orderID, -1)
+	}
+	return nil
+}
+
+// periodSplit is a half-open period [from, to) cut at the start of today (UTC) , a zero time is an open bound.
+type periodSplit struct {
+	rollup               bool
+	rollupFrom, rollupTo time.Time
+	live                 bool
+	liveFrom, liveTo     time.Time
+}
+
+// splitPeriod tells which part of the period is read from the rollups (closed days) and which from
+// the live tables (today and later). Bounds that are not UTC midnights can not be answered from
+// per day rows , the whole period is then read live.
+func splitPeriod(from, to time.Time) periodSplit {
+	if !isUTCMidnight(from) || !isUTCMidnight(to) {
+		return periodSplit{live: true, liveFrom: from, liveTo: to}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	var s periodSplit
+	s.rollupFrom, s.rollupTo = from, today
+	if !to.IsZero() && to.Before(today) {
+		s.rollupTo = to
+	}
+	s.rollup = from.IsZero() || from.Before(s.rollupTo)
+
+	s.liveFrom, s.liveTo = today, to
+	if from.After(today) {
+		s.liveFrom = from
+	}
+	s.live = to.IsZero() || to.After(s.liveFrom)
+	return s
+}
+
+func isUTCMidnight(t time.Time) bool {
+	return t.IsZero() || t.Equal(t.UTC().Truncate(24*time.Hour))
+}
+
+// SalesRollupModel wraps a sql.DB connection pool.
+type SalesRollupModel struct {
+	DB *sql.DB
+}
+
+// Rebuild recomputes the rollups of the days in [from, to] from the live tables and returns the number of
+// days written. The tables are locked for the rebuild so order status changes wait for it instead of
+// being lost.
+func (m SalesRollupModel) Rebuild(ctx context.Context, from, to time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE daily_product_sales, daily_sales IN EXCLUSIVE MODE`)
+	if err != nil {
+		return 0, err
+	}
+
+	fromDay, toDay := from.Format(time.DateOnly), to.Format(time.DateOnly)
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM daily_product_sales WHERE day BETWEEN $1::date AND $2::date`, fromDay, toDay)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM daily_sales WHERE day BETWEEN $1::date AND $2::date`, fromDay, toDay)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO daily_product_sales (day, product_id, units, revenue)
+		SELECT (o.created_at AT TIME ZONE 'UTC')::date AS day, op.product_id,
+			SUM(op.quantity), SUM(op.quantity * op.price_at_purchase)
+		FROM orders o
+		JOIN order_products op ON op.order_id = o.id
+		WHERE o.status = ANY($1)
+		AND (o.created_at AT TIME ZONE 'UTC')::date BETWEEN $2::date AND $3::date
+		GROUP BY day, op.product_id`
+	_, err = tx.ExecContext(ctx, query, pq.Array(RevenueStatuses), fromDay, toDay)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		INSERT INTO daily_sales (day, orders, units, revenue)
+		SELECT (o.created_at AT TIME ZONE 'UTC')::date AS day, count(*),
+			COALESCE(SUM(units.quantity), 0), SUM(o.total_amount)
+		FROM orders o
+		LEFT JOIN LATERAL (
+			SELECT SUM(op.quantity) AS quantity FROM order_products op WHERE op.order_id = o.id
+		) units ON TRUE
+		WHERE o.status = ANY($1)
+		AND (o.created_at AT TIME ZONE 'UTC')::date BETWEEN $2::date AND $3::date
+		GROUP BY day`
+	result, err := tx.ExecContext(ctx, query, pq.Array(RevenueStatuses), fromDay, toDay)
+	if err != nil {
+		return 0, err
+	}
+	days, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return days, nil
+}
@@ -21,6 +21,8 @@ type User struct {
 	LastName  string    `json:"last_name"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	StripeCustomerID string `json:"-"` // empty until the user saves a first card
 }
 
 // password encapsulates both the plaintext and hashed password.
@@ -58,16 +60,25 @@ type UserModel struct {
 }
 
 // Insert adds a new user to the database and updates the User struct with its ID and timestamps.
-func (m UserModel) Insert(user *User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	return insertUser(ctx, m.DB, user)
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertUser is Insert on a connection or a transaction.
+func insertUser(ctx context.Context, q rowQueryer, user *User) error {
 	query := `
 		INSERT INTO users (email, password_hash, role, first_name, last_name, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 		RETURNING id, created_at, updated_at
 	`
-	err := m.DB.QueryRowContext(ctx, query,
+	err := q.QueryRowContext(ctx, query,
 		user.Email,
 		string(user.Password.hash),
 		user.Role,
@@ -85,12 +96,13 @@ func (m UserModel) Insert(user *User) error {
 }
 
 // GetByEmail retrieves a user from the database by their email address.
-func (m UserModel) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT id, email, password_hash, role, first_name, last_name, created_at, updated_at
+		SELECT id, email, password_hash, role, COALESCE(first_name, ''), COALESCE(last_name, ''), created_at, updated_at,
+			COALESCE(stripe_customer_id, '')
 		FROM users
 		WHERE email = $1
 	`
@@ -105,6 +117,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.StripeCustomerID,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -116,19 +129,21 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetByID(id int64) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m UserModel) GetByID(ctx context.Context, id int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT id, email, password_hash, role, first_name, last_name, created_at, updated_at
+		SELECT id, email, password_hash, role, COALESCE(first_name, ''), COALESCE(last_name, ''), created_at, updated_at,
+			COALESCE(stripe_customer_id, '')
 		FROM users
 		WHERE id = $1
 	`
 	var user User
 	var passwordHash string
 	err := m.DB.QueryRowContext(ctx, query, id).
-		Scan(&user.ID, &user.Email, &passwordHash, &user.Role, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt)
+		Scan(&user.ID, &user.Email, &passwordHash, &user.Role, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt,
+			&user.StripeCustomerID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrRecordNotFound
@@ -139,6 +154,30 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 	return &user, nil
 }
 
+// SetStripeCustomerID links the user to their Stripe Customer.
+func (m UserModel) SetStripeCustomerID(ctx context.Context, id int64, customerID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET stripe_customer_id = $1, updated_at = NOW()
+		WHERE id = $2`
+	result, err := m.DB.ExecContext(ctx, query, customerID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 // ValidateUser uses the provided validator to enforce rules on the User fields.
 func ValidateUser(v *validator.Validator, user *User) {
 	// Ensure email is provided and matches a valid email format.
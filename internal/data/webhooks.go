@@ -0,0 +1,548 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+
+	"interviewTask/internal/jobs"
+	"interviewTask/internal/validator"
+)
+
+// webhook event types partners can subscribe to.
+const (
+	WebhookOrderPaid           = "order.paid"
+	WebhookOrderCancelled      = "order.cancelled"
+	WebhookOrderRefunded       = "order.refunded"
+	WebhookProductPriceChanged = "product.price_changed"
+	WebhookProductStockChanged = "product.stock_changed"
+)
+
+var WebhookEvents = []string{
+	WebhookOrderPaid, WebhookOrderCancelled, WebhookOrderRefunded,
+	WebhookProductPriceChanged, WebhookProductStockChanged,
+}
+
+// webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // out of attempts , only a manual redelivery sends it again
+)
+
+var DeliveryStatuses = []string{DeliveryPending, DeliverySucceeded, DeliveryFailed}
+
+// WebhookSubscription is a partner endpoint and the events it receives. The secret signs every payload.
+type WebhookSubscription struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"-"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedBy   *int64    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one event on its way to one subscription.
+type WebhookDelivery struct {
+	ID             int64             `json:"id"`
+	SubscriptionID int64             `json:"subscription_id"`
+	EventID        int64             `json:"event_id"`
+	EventType      string            `json:"event_type"`
+	Status         string            `json:"status"`
+	Attempts       int               `json:"attempts"`
+	NextAttemptAt  time.Time         `json:"next_attempt_at"`
+	DeliveredAt    *time.Time        `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	Log            []*WebhookAttempt `json:"log,omitempty"`
+	Payload        json.RawMessage   `json:"payload,omitempty"`
+}
+
+// WebhookAttempt is one HTTP request of a delivery , StatusCode is nil when no response came back.
+type WebhookAttempt struct {
+	StatusCode   *int      `json:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	DurationMS   int       `json:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// DueDelivery is a delivery claimed for sending , with everything needed to send it.
+type DueDelivery struct {
+	ID             int64
+	Attempts       int // including the one about to be made
+	URL            string
+	Secret         string
+	EventID        int64
+	EventType      string
+	Payload        json.RawMessage
+	EventCreatedAt time.Time
+}
+
+// JobDeliverWebhook is the job kind that makes one attempt at a delivery.
+const JobDeliverWebhook = "webhook.deliver"
+
+// WebhookDeliveryJob is the payload of a JobDeliverWebhook job.
+type WebhookDeliveryJob struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// WebhookModel wraps a sql.DB connection pool.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// queueWebhookEvent writes the event to the outbox with a delivery for every active subscription to its type ,
+// inside the transaction of the change it describes. Nothing is written when nobody subscribes.
+func queueWebhookEvent(ctx context.Context, tx *sql.Tx, eventType string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		WITH subscribers AS (
+			SELECT id FROM webhook_subscriptions WHERE active AND $1 = ANY(events)
+		), event AS (
+			INSERT INTO webhook_events (event_type, payload)
+			SELECT $1, $2::jsonb WHERE EXISTS (SELECT 1 FROM subscribers)
+			RETURNING id
+		)
+		INSERT INTO webhook_deliveries (subscription_id, event_id)
+		SELECT s.id, e.id FROM subscribers s CROSS JOIN event e
+		RETURNING id`
+	rows, err := tx.QueryContext(ctx, query, eventType, string(js))
+	if err != nil {
+		return err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = enqueueDelivery(ctx, tx, id, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueDelivery queues the job sending a delivery at runAt (zero for now) , a delivery has at most one
+// queued job.
+func enqueueDelivery(ctx context.Context, tx *sql.Tx, deliveryID int64, runAt time.Time) error {
+	_, err := jobs.Enqueue(ctx, tx, JobDeliverWebhook, WebhookDeliveryJob{DeliveryID: deliveryID},
+		jobs.RunAt(runAt), jobs.UniqueKey(fmt.Sprintf("%s:%d", JobDeliverWebhook, deliveryID)))
+	return err
+}
+
+// scanIDs reads and closes a single column result of ids.
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GenerateWebhookSecret returns a new signing secret , "whsec_<64 hex characters>".
+func GenerateWebhookSecret() (string, error) {
+	secret, err := randomToken(32, hex.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + secret, nil
+}
+
+// Insert creates the subscription , sub.Secret must be set.
+func (m WebhookModel) Insert(ctx context.Context, sub *WebhookSubscription, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_subscriptions (url, secret, events, description, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at`
+	err = tx.QueryRowContext(ctx, query, sub.URL, sub.Secret, pq.Array(sub.Events), sub.Description, sub.Active, sub.CreatedBy).
+		Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditWebhookCreated, sub.ID, nil, sub, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const webhookColumns = `id, url, secret, events, description, active, created_by, created_at, updated_at`
+
+func scanWebhook(row rowScanner, s *WebhookSubscription) error {
+	return row.Scan(&s.ID, &s.URL, &s.Secret, pq.Array(&s.Events), &s.Description, &s.Active, &s.CreatedBy,
+		&s.CreatedAt, &s.UpdatedAt)
+}
+
+func (m WebhookModel) Get(ctx context.Context, id int64) (*WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var s WebhookSubscription
+	err := scanWebhook(m.DB.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = $1`, id), &s)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (m WebhookModel) GetAll(ctx context.Context) ([]*WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*WebhookSubscription{}
+	for rows.Next() {
+		var s WebhookSubscription
+		if err = scanWebhook(rows, &s); err != nil {
+			return nil, err
+		}
+		subs = append(subs, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// Update saves the url, events, description and active flag of the subscription.
+func (m WebhookModel) Update(ctx context.Context, sub *WebhookSubscription, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old WebhookSubscription
+	err = scanWebhook(tx.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = $1 FOR UPDATE`, sub.ID), &old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $1, events = $2, description = $3, active = $4, updated_at = NOW()
+		WHERE id = $5
+		RETURNING updated_at`
+	err = tx.QueryRowContext(ctx, query, sub.URL, pq.Array(sub.Events), sub.Description, sub.Active, sub.ID).Scan(&sub.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditWebhookUpdated, sub.ID, &old, sub, nil)
+	if err != nil {
+		return err
+	}
+
+	// deliveries are not sent while a subscription is paused , send the ones waiting when it resumes.
+	if sub.Active && !old.Active {
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM webhook_deliveries WHERE subscription_id = $1 AND status = 'pending'`, sub.ID)
+		if err != nil {
+			return err
+		}
+		ids, err := scanIDs(rows)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err = enqueueDelivery(ctx, tx, id, time.Time{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the subscription and its deliveries.
+func (m WebhookModel) Delete(ctx context.Context, id int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old WebhookSubscription
+	err = scanWebhook(tx.QueryRowContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1 RETURNING `+webhookColumns, id), &old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditWebhookDeleted, id, &old, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDeliveries returns a page of the subscription's deliveries , newest first. An empty status means all.
+func (m WebhookModel) GetDeliveries(ctx context.Context, subscriptionID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT d.id, d.subscription_id, d.event_id, e.event_type, d.status, d.attempts, d.next_attempt_at,
+			d.delivered_at, d.created_at, count(*) OVER()
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.id = d.event_id
+		WHERE d.subscription_id = $1 AND ($2 = '' OR d.status = $2)
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT $3 OFFSET $4`
+
+	rows, err := m.DB.QueryContext(ctx, query, subscriptionID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err = rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.DeliveredAt, &d.CreatedAt, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetDelivery returns a delivery with its payload and the log of its attempts.
+func (m WebhookModel) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT d.id, d.subscription_id, d.event_id, e.event_type, d.status, d.attempts, d.next_attempt_at,
+			d.delivered_at, d.created_at, e.payload
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.id = d.event_id
+		WHERE d.id = $1`
+
+	var d WebhookDelivery
+	var payload []byte
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status,
+		&d.Attempts, &d.NextAttemptAt, &d.DeliveredAt, &d.CreatedAt, &payload)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	d.Payload = payload
+
+	query = `
+		SELECT status_code, error, response_body, duration_ms, created_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY created_at, id`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d.Log = []*WebhookAttempt{}
+	for rows.Next() {
+		var a WebhookAttempt
+		if err = rows.Scan(&a.StatusCode, &a.Error, &a.ResponseBody, &a.DurationMS, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.Log = append(d.Log, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Redeliver sends the delivery again as soon as possible with a fresh set of attempts , whatever its status.
+func (m WebhookModel) Redeliver(ctx context.Context, id int64, actor Actor) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1`
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordAudit(ctx, tx, actor, AuditWebhookRedelivered, id, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueDelivery(ctx, tx, id, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Claim counts an attempt at a pending delivery of an active subscription and returns it for sending ,
+// ErrRecordNotFound when it is no longer to be sent (delivered, failed for good, deleted or paused).
+func (m WebhookModel) Claim(ctx context.Context, id int64) (*DueDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1
+		FROM webhook_subscriptions s, webhook_events e
+		WHERE d.id = $1 AND d.status = 'pending'
+		AND s.id = d.subscription_id AND s.active AND e.id = d.event_id
+		RETURNING d.id, d.attempts, s.url, s.secret, e.id, e.event_type, e.payload, e.created_at`
+
+	var d DueDelivery
+	var payload []byte
+	err := m.DB.QueryRowContext(ctx, query, id).
+		Scan(&d.ID, &d.Attempts, &d.URL, &d.Secret, &d.EventID, &d.EventType, &payload, &d.EventCreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	d.Payload = payload
+	return &d, nil
+}
+
+// RecordAttempt logs an attempt and moves the delivery on , to succeeded, to a retry at retryAt or (with a nil
+// retryAt) to failed.
+func (m WebhookModel) RecordAttempt(ctx context.Context, deliveryID int64, attempt *WebhookAttempt, succeeded bool, retryAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_delivery_attempts (delivery_id, status_code, error, response_body, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, deliveryID, attempt.StatusCode, attempt.Error, attempt.ResponseBody, attempt.DurationMS)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case succeeded:
+		query = `UPDATE webhook_deliveries SET status = 'succeeded', delivered_at = NOW() WHERE id = $1`
+		_, err = tx.ExecContext(ctx, query, deliveryID)
+	case retryAt == nil:
+		query = `UPDATE webhook_deliveries SET status = 'failed' WHERE id = $1`
+		_, err = tx.ExecContext(ctx, query, deliveryID)
+	default:
+		query = `UPDATE webhook_deliveries SET next_attempt_at = $2 WHERE id = $1`
+		_, err = tx.ExecContext(ctx, query, deliveryID, *retryAt)
+		if err == nil {
+			err = enqueueDelivery(ctx, tx, deliveryID, *retryAt)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func ValidateWebhookSubscription(v *validator.Validator, sub *WebhookSubscription) {
+	u, err := url.Parse(sub.URL)
+	v.Check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url", "must be an absolute http(s) URL")
+	v.Check(len(sub.URL) <= 2048, "url", "must not exceed 2048 characters")
+	v.Check(len(sub.Events) > 0, "events", "must contain at least one event")
+	for _, event := range sub.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "invalid event "+event)
+	}
+	v.Check(len(sub.Description) <= 500, "description", "must not exceed 500 characters")
+}
+
+// orderEvent is the payload of the order events , without the payment references.
+func orderEvent(o *Order, previousStatus string) map[string]interface{} {
+	return map[string]interface{}{
+		"order_id":        o.ID,
+		"user_id":         o.UserID,
+		"total_amount":    o.TotalAmount,
+		"status":          o.Status,
+		"previous_status": previousStatus,
+		"created_at":      o.CreatedAt,
+		"updated_at":      o.UpdatedAt,
+	}
+}
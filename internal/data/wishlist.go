@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WishlistItem is a product saved by a user , with the product details for display.
+type WishlistItem struct {
+	ProductID         int64     `json:"product_id"`
+	Name              string    `json:"name"`
+	Price             float64   `json:"price"`
+	InventoryCount    int       `json:"inventory_count"`
+	NotifyBackInStock bool      `json:"notify_back_in_stock"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// WishlistModel wraps a sql.DB connection pool.
+type WishlistModel struct {
+	DB *sql.DB
+}
+
+// GetAll lists the user's wishlist , most recently saved first.
+func (m WishlistModel) GetAll(ctx context.Context, userID int64) ([]*WishlistItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT w.product_id, p.name, p.price, p.inventory_count, w.notify_back_in_stock, w.created_at
+		FROM wishlist_items w
+		JOIN products p ON p.id = w.product_id
+		WHERE w.user_id = $1
+		ORDER BY w.created_at DESC, w.product_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*WishlistItem{}
+	for rows.Next() {
+		var item WishlistItem
+		err = rows.Scan(&item.ProductID, &item.Name, &item.Price, &item.InventoryCount, &item.NotifyBackInStock, &item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Add saves a product to the user's wishlist , saving it again only updates the notification preference.
+func (m WishlistModel) Add(ctx context.Context, userID, productID int64, notifyBackInStock bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO wishlist_items (user_id, product_id, notify_back_in_stock)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, product_id) DO UPDATE SET notify_back_in_stock = EXCLUDED.notify_back_in_stock`
+	_, err := m.DB.ExecContext(ctx, query, userID, productID, notifyBackInStock)
+	if err != nil {
+		// Foreign key violation (PostgreSQL error code 23503) , the product does not exist.
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Remove deletes a product from the user's wishlist.
+func (m WishlistModel) Remove(ctx context.Context, userID, productID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM wishlist_items WHERE user_id = $1 AND product_id = $2`, userID, productID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// queueBackInStockNotifications writes a back_in_stock notification for every user watching one of the
+// products , it must run in the transaction that raised their stock from zero.
+func queueBackInStockNotifications(ctx context.Context, tx *sql.Tx, productIDs []int64) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO notifications_outbox (user_id, kind, payload)
+		SELECT w.user_id, $2, jsonb_build_object('product_id', p.id, 'name', p.name, 'inventory_count', p.inventory_count)
+		FROM wishlist_items w
+		JOIN products p ON p.id = w.product_id
+		WHERE w.product_id = ANY($1) AND w.notify_back_in_stock
+		RETURNING id`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(productIDs), NotificationBackInStock)
+	if err != nil {
+		return err
+	}
+	return enqueueNotifications(ctx, tx, rows)
+}
@@ -0,0 +1,412 @@
+// Package jobs is a job queue kept in PostgreSQL. Jobs are rows of the jobs table , enqueued on their own or
+// inside the transaction of the change that needs them (so they exist if and only if it commits), and run by
+// a pool of workers that claim them with SELECT ... FOR UPDATE SKIP LOCKED , any number of API instances can
+// share the queue.
+//
+// A failed job is retried with exponential backoff until it runs out of attempts and is left dead , for an
+// admin to look at and retry. Recurring jobs are registered with Schedule and enqueued by exactly one
+// instance per due time.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lib/pq"
+)
+
+// job statuses.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead" // out of attempts or failed permanently , only a manual retry runs it again
+)
+
+var Statuses = []string{StatusQueued, StatusRunning, StatusSucceeded, StatusDead}
+
+var tracer = otel.Tracer("interviewTask/internal/jobs")
+
+const (
+	defaultMaxAttempts = 5
+	firstRetry         = 10 * time.Second
+	maxRetry           = time.Hour
+	kindCleanup        = "jobs.cleanup"
+)
+
+// Job is a claimed job as its handler sees it.
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Attempt     int // 1 on the first run
+	MaxAttempts int
+	RunAt       time.Time
+	CreatedAt   time.Time
+}
+
+// HandlerFunc runs a job. A returned error fails the attempt , wrap it with Permanent when retrying is useless.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Logger is the part of jsonlog.Logger the queue writes to.
+type Logger interface {
+	PrintInfo(message string, properties map[string]string)
+	PrintError(err error, properties map[string]string)
+}
+
+// Config tunes the workers.
+type Config struct {
+	Workers      int           // jobs run at the same time by this instance
+	PollInterval time.Duration // how often idle workers look for due jobs
+	Timeout      time.Duration // how long one run may take , a job whose worker died is run again after it
+	Retention    time.Duration // how long succeeded jobs are kept
+}
+
+// Queue registers the handlers and schedules and runs the workers.
+type Queue struct {
+	db        *sql.DB
+	cfg       Config
+	logger    Logger
+	handlers  map[string]HandlerFunc
+	schedules []*schedule
+	wake      chan struct{}
+}
+
+type schedule struct {
+	name    string
+	kind    string
+	payload interface{}
+	spec    Schedule
+}
+
+// New returns a queue with no handlers yet , the retention cleanup is scheduled hourly.
+func New(db *sql.DB, cfg Config, logger Logger) *Queue {
+	q := &Queue{
+		db:       db,
+		cfg:      cfg,
+		logger:   logger,
+		handlers: map[string]HandlerFunc{},
+		wake:     make(chan struct{}, 1),
+	}
+	q.Handle(kindCleanup, q.cleanup)
+	q.Schedule(kindCleanup, Every(time.Hour), kindCleanup, nil)
+	return q
+}
+
+// Handle registers the handler of a kind , call it before Run.
+func (q *Queue) Handle(kind string, h HandlerFunc) {
+	q.handlers[kind] = h
+}
+
+// Register is Handle for a handler that takes the decoded payload , a payload that does not decode into T
+// fails the job permanently.
+func Register[T any](q *Queue, kind string, fn func(ctx context.Context, payload T) error) {
+	q.Handle(kind, func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("decode %s payload: %w", kind, err))
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Schedule enqueues a job of kind with payload at every due time of spec , name identifies the schedule
+// across restarts and instances. Call it before Run.
+func (q *Queue) Schedule(name string, spec Schedule, kind string, payload interface{}) {
+	q.schedules = append(q.schedules, &schedule{name: name, kind: kind, payload: payload, spec: spec})
+}
+
+// Enqueue adds a job and wakes an idle worker.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}, opts ...Option) (int64, error) {
+	id, err := Enqueue(ctx, q.db, kind, payload, opts...)
+	if err == nil {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return id, err
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Option changes how a job is enqueued.
+type Option func(*options)
+
+type options struct {
+	runAt       time.Time
+	maxAttempts int
+	uniqueKey   string
+}
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) Option {
+	return func(o *options) { o.runAt = t }
+}
+
+// MaxAttempts overrides the default of 5 attempts.
+func MaxAttempts(n int) Option {
+	return func(o *options) { o.maxAttempts = n }
+}
+
+// UniqueKey keeps at most one queued job with the key , enqueuing it again only moves the queued job to the
+// earlier of both run times. A running job does not count , so a job can queue its own follow-up.
+func UniqueKey(key string) Option {
+	return func(o *options) { o.uniqueKey = key }
+}
+
+// Enqueue adds a job on q , pass the transaction of a change to enqueue the job only if it commits.
+// The payload is any JSON encodable value.
+func Enqueue(ctx context.Context, q rowQueryer, kind string, payload interface{}, opts ...Option) (int64, error) {
+	o := options{maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	js := []byte("{}")
+	if payload != nil {
+		var err error
+		if js, err = json.Marshal(payload); err != nil {
+			return 0, err
+		}
+	}
+
+	var runAt interface{}
+	if !o.runAt.IsZero() {
+		runAt = o.runAt
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts, unique_key, run_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), COALESCE($5, NOW()))
+		ON CONFLICT (unique_key) WHERE status = 'queued' AND unique_key IS NOT NULL
+		DO UPDATE SET run_at = LEAST(jobs.run_at, EXCLUDED.run_at), updated_at = NOW()
+		RETURNING id`
+	var id int64
+	err := q.QueryRowContext(ctx, query, kind, string(js), o.maxAttempts, o.uniqueKey, runAt).Scan(&id)
+	return id, err
+}
+
+// permanentError fails a job without further attempts.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as not worth retrying , the job goes straight to dead.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Run starts the workers and the scheduler and blocks until ctx is cancelled and the jobs being run
+// have finished. No job is claimed after ctx is cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < q.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.runSchedules(ctx)
+	}()
+
+	wg.Wait()
+}
+
+// kinds lists the registered kinds , a worker only claims jobs it has a handler for.
+func (q *Queue) kinds() []string {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func (q *Queue) work(ctx context.Context) {
+	kinds := q.kinds()
+
+	for ctx.Err() == nil {
+		job, err := q.claim(kinds)
+		if err != nil {
+			q.logger.PrintError(err, map[string]string{"component": "jobs"})
+		}
+		if job != nil {
+			q.run(job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// claim takes the next due job , or one whose worker died , and marks it running until the timeout.
+// It returns a nil job when none is due.
+func (q *Queue) claim(kinds []string) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $2),
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1)
+			AND ((status = 'queued' AND run_at <= NOW()) OR (status = 'running' AND locked_until < NOW()))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts, max_attempts, run_at, created_at`
+
+	var job Job
+	var payload []byte
+	lease := q.cfg.Timeout + time.Minute
+	err := q.db.QueryRowContext(ctx, query, pq.Array(kinds), lease.Seconds()).
+		Scan(&job.ID, &job.Kind, &payload, &job.Attempt, &job.MaxAttempts, &job.RunAt, &job.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	job.Payload = payload
+	return &job, nil
+}
+
+// run calls the handler and records the outcome. The handler gets its own context , a shutdown lets it
+// finish instead of cutting it off.
+func (q *Queue) run(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.cfg.Timeout)
+	defer cancel()
+
+	// every run is the root span of its own trace , the queries of the handler are under it.
+	ctx, span := tracer.Start(ctx, "job "+job.Kind, trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", job.ID),
+			attribute.String("job.kind", job.Kind),
+			attribute.Int("job.attempt", job.Attempt),
+		))
+	defer span.End()
+
+	start := time.Now()
+	err := q.call(ctx, job)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	props := map[string]string{
+		"component":   "jobs",
+		"job_id":      fmt.Sprint(job.ID),
+		"kind":        job.Kind,
+		"attempt":     fmt.Sprint(job.Attempt),
+		"duration_ms": fmt.Sprint(time.Since(start).Milliseconds()),
+	}
+	if sc := span.SpanContext(); sc.IsValid() {
+		props["trace_id"] = sc.TraceID().String()
+	}
+
+	var perr permanentError
+	switch {
+	case err == nil:
+		err = q.finish(job.ID, StatusSucceeded, "", time.Time{})
+	case errors.As(err, &perr) || job.Attempt >= job.MaxAttempts:
+		q.logger.PrintError(fmt.Errorf("job dead: %w", err), props)
+		err = q.finish(job.ID, StatusDead, err.Error(), time.Time{})
+	default:
+		q.logger.PrintError(fmt.Errorf("job failed: %w", err), props)
+		err = q.finish(job.ID, StatusQueued, err.Error(), time.Now().Add(backoff(job.Attempt)))
+	}
+	if err != nil {
+		q.logger.PrintError(err, props)
+	}
+}
+
+// call runs the handler , turning a panic into an error.
+func (q *Queue) call(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	h, ok := q.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for job kind %s", job.Kind))
+	}
+	return h(ctx, job)
+}
+
+// finish records the outcome of a run , a queued status retries the job at retryAt.
+func (q *Queue) finish(id int64, status, lastError string, retryAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if status == StatusQueued {
+		query := `
+			UPDATE jobs
+			SET status = 'queued', run_at = $2, last_error = $3, locked_until = NULL, updated_at = NOW()
+			WHERE id = $1`
+		_, err := q.db.ExecContext(ctx, query, id, retryAt, lastError)
+		// a queued job with the same unique key already covers the retry.
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+			return err
+		}
+		status, lastError = StatusSucceeded, "superseded by a queued job with the same key"
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = $2, last_error = $3, locked_until = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1`
+	_, err := q.db.ExecContext(ctx, query, id, status, lastError)
+	return err
+}
+
+// backoff is the wait after the given failed attempt , doubling from firstRetry up to maxRetry with up to
+// 20% jitter.
+func backoff(attempt int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempt && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// cleanup deletes the succeeded jobs older than the retention , dead jobs stay until someone deals with them.
+func (q *Queue) cleanup(ctx context.Context, _ *Job) error {
+	query := `DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < NOW() - make_interval(secs => $1)`
+	_, err := q.db.ExecContext(ctx, query, q.cfg.Retention.Seconds())
+	return err
+}
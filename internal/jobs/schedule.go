@@ -0,0 +1,263 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule gives the due times of a recurring job.
+type Schedule interface {
+	// Next returns the first due time strictly after t.
+	Next(t time.Time) time.Time
+}
+
+type every time.Duration
+
+// Every is due every d , on multiples of d (since the zero time) so all instances agree on the times.
+// A d of zero or less is never due.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+func (e every) Next(t time.Time) time.Time {
+	d := time.Duration(e)
+	if d <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(d).Add(d)
+}
+
+// cron is a parsed crontab line , each field is a bit set of the allowed values.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// ParseSchedule parses "@every <duration>", "@hourly", "@daily", "@weekly", "@monthly" or a five field
+// crontab line "minute hour day-of-month month day-of-week" in UTC. Fields take "*", numbers, ranges "a-b",
+// steps "*/n" or "a-b/n" and comma separated lists of those , day-of-week 0 (or 7) is Sunday.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		duration, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		if duration < time.Second {
+			return nil, fmt.Errorf("schedule %q: must be at least one second", spec)
+		}
+		return Every(duration), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields, got %d", spec, len(fields))
+	}
+
+	var c cron
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("schedule %q: minute: %w", spec, err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("schedule %q: hour: %w", spec, err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of month: %w", spec, err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("schedule %q: month: %w", spec, err)
+	}
+	if c.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of week: %w", spec, err)
+	}
+	// 7 is Sunday too.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar, c.dowStar = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(a)
+			hi, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c cron) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	// five years include a leap year , a schedule still not due by then never is (February 30th).
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron , when both day fields are restricted either one matching is enough.
+func (c cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// runSchedules enqueues the scheduled jobs as they become due until ctx is cancelled.
+func (q *Queue) runSchedules(ctx context.Context) {
+	for {
+		// a failed round (say the database is down) is tried again on the next one.
+		if err := q.enqueueDue(); err != nil {
+			q.logger.PrintError(err, map[string]string{"component": "jobs"})
+		}
+
+		// sleep until the earliest due time , but check at least every minute in case another instance
+		// fell behind.
+		now := time.Now()
+		wait := time.Minute
+		for _, s := range q.schedules {
+			if next := s.spec.Next(now); !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// enqueueDue enqueues the schedules whose time has come. The job_schedules row is locked for that , so only
+// one instance enqueues a due time and the next one is recorded with it.
+func (q *Queue) enqueueDue() error {
+	var errs []error
+	for _, s := range q.schedules {
+		if err := q.enqueueSchedule(s); err != nil {
+			errs = append(errs, fmt.Errorf("schedule %s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (q *Queue) enqueueSchedule(s *schedule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	next := s.spec.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO job_schedules (name, next_run_at) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`,
+		s.name, next)
+	if err != nil {
+		return err
+	}
+
+	var due time.Time
+	query := `SELECT next_run_at FROM job_schedules WHERE name = $1 AND next_run_at <= $2 FOR UPDATE SKIP LOCKED`
+	err = tx.QueryRowContext(ctx, query, s.name, now).Scan(&due)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	// a missed due time (the API was down) runs once , not once per time missed.
+	_, err = Enqueue(ctx, tx, s.kind, s.payload, RunAt(due), UniqueKey("schedule:"+s.name))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE job_schedules SET next_run_at = $2, last_run_at = NOW() WHERE name = $1`, s.name, next)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
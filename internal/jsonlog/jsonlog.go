@@ -1,12 +1,15 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"os"
 	"runtime/debug"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Level int
@@ -45,6 +48,11 @@ func New(out io.Writer, minLevel Level) *Logger {
 }
 
 func (logger *Logger) Print(level Level, message string, properties map[string]string) (int, error) {
+	return logger.print(context.Background(), level, message, properties)
+}
+
+// print writes one line , with the trace and span ids of the span in ctx when there is one.
+func (logger *Logger) print(ctx context.Context, level Level, message string, properties map[string]string) (int, error) {
 	if level < logger.minLevel {
 		return 0, nil
 	}
@@ -55,6 +63,8 @@ func (logger *Logger) Print(level Level, message string, properties map[string]s
 		Message    string
 		Properties map[string]string
 		Trace      string
+		TraceID    string `json:",omitempty"`
+		SpanID     string `json:",omitempty"`
 	}{
 		Level:      level.string(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
@@ -65,6 +75,10 @@ func (logger *Logger) Print(level Level, message string, properties map[string]s
 	if level >= LevelError {
 		aux.Trace = string(debug.Stack())
 	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		aux.TraceID = sc.TraceID().String()
+		aux.SpanID = sc.SpanID().String()
+	}
 
 	var line []byte
 	line, err := json.Marshal(aux)
@@ -86,6 +100,16 @@ func (Logger *Logger) PrintError(err error, properties map[string]string) {
 	Logger.Print(LevelError, err.Error(), properties)
 }
 
+// PrintInfoContext is PrintInfo tagged with the trace of ctx , so the line can be found from the trace.
+func (logger *Logger) PrintInfoContext(ctx context.Context, message string, properties map[string]string) {
+	logger.print(ctx, LevelInfo, message, properties)
+}
+
+// PrintErrorContext is PrintError tagged with the trace of ctx.
+func (logger *Logger) PrintErrorContext(ctx context.Context, err error, properties map[string]string) {
+	logger.print(ctx, LevelError, err.Error(), properties)
+}
+
 func (logger *Logger) PrintFatal(err error, properties map[string]string) {
 	logger.Print(LevelFatal, err.Error(), properties)
 	os.Exit(1)
@@ -0,0 +1,187 @@
+// Package mailer renders the notification emails from their templates and sends them through a Mailer ,
+// SMTP in production and the log in development.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is one email , Text and HTML are alternatives of the same content.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends emails , implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTP sends through an SMTP server , upgrading the connection with STARTTLS when the server offers it.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string // empty for no authentication
+	Password string
+	Sender   string // "Buy <no-reply@example.com>"
+}
+
+// Send delivers msg , ctx bounds the whole SMTP conversation.
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	if _, err := mail.ParseAddress(msg.To); err != nil || strings.ContainsAny(msg.To, "\r\n<>") {
+		return fmt.Errorf("mailer: invalid recipient %q", msg.To)
+	}
+
+	body, err := s.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, err := bareAddress(s.Sender)
+	if err != nil {
+		return err
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	if err = c.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(body); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose builds the MIME message , a multipart/alternative of the text and HTML bodies.
+func (s SMTP) compose(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if from, err := bareAddress(s.Sender); err == nil {
+		if _, d, ok := strings.Cut(from, "@"); ok {
+			domain = d
+		}
+	}
+
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMessage-ID: <%s@%s>\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%q\r\n\r\n",
+		s.Sender, msg.To, mime.QEncoding.Encode("utf-8", msg.Subject), time.Now().Format(time.RFC1123Z),
+		hex.EncodeToString(id), domain, mw.Boundary())
+	out := bytes.NewBufferString(header)
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err = qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// bareAddress returns the address of "Name <address>" or "address".
+func bareAddress(address string) (string, error) {
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("mailer: sender %q: %w", address, err)
+	}
+	return a.Address, nil
+}
+
+// Logger is the part of jsonlog.Logger the log mailer writes to.
+type Logger interface {
+	PrintInfo(message string, properties map[string]string)
+}
+
+// Log writes the emails to the log instead of sending them , for development.
+type Log struct {
+	Logger Logger
+}
+
+func (l Log) Send(_ context.Context, msg Message) error {
+	l.Logger.PrintInfo("email not sent , no SMTP server configured", map[string]string{
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"text":    msg.Text,
+	})
+	return nil
+}
@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+// every notification kind with an email has templates/<kind>.tmpl , defining "subject", "plainBody" and
+// "htmlBody". They are executed with a TemplateData.
+
+//go:embed templates
+var templateFS embed.FS
+
+var ErrNoTemplate = errors.New("mailer: no email template for this kind")
+
+// TemplateData is what the templates see , Data is the notification payload.
+type TemplateData struct {
+	FirstName string
+	Data      map[string]interface{}
+}
+
+var funcs = map[string]interface{}{
+	// money formats an amount , the payload is decoded with UseNumber so ids print as they are.
+	"money": func(v interface{}) string {
+		switch n := v.(type) {
+		case json.Number:
+			f, err := n.Float64()
+			if err != nil {
+				return n.String()
+			}
+			return fmt.Sprintf("$%.2f", f)
+		case float64:
+			return fmt.Sprintf("$%.2f", n)
+		case nil:
+			return ""
+		default:
+			return fmt.Sprint(n)
+		}
+	},
+}
+
+// Render executes the templates of kind , ErrNoTemplate when it has none.
+func Render(kind, to string, data TemplateData) (Message, error) {
+	file := "templates/" + kind + ".tmpl"
+	if strings.ContainsAny(kind, "/.") {
+		return Message{}, ErrNoTemplate
+	}
+	if _, err := templateFS.Open(file); err != nil {
+		return Message{}, ErrNoTemplate
+	}
+	if data.FirstName == "" {
+		data.FirstName = "there"
+	}
+
+	text, err := template.New("").Funcs(funcs).Option("missingkey=zero").ParseFS(templateFS, file)
+	if err != nil {
+		return Message{}, err
+	}
+	html, err := htmltemplate.New("").Funcs(funcs).Option("missingkey=zero").ParseFS(templateFS, file)
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{To: to}
+	var buf bytes.Buffer
+	if err = text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return Message{}, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err = text.ExecuteTemplate(&buf, "plainBody", data); err != nil {
+		return Message{}, err
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	buf.Reset()
+	if err = html.ExecuteTemplate(&buf, "htmlBody", data); err != nil {
+		return Message{}, err
+	}
+	msg.HTML = strings.TrimSpace(buf.String()) + "\n"
+
+	return msg, nil
+}
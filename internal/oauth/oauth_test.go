@@ -0,0 +1,161 @@
+package oauth_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"interviewTask/internal/oauth"
+	"interviewTask/internal/oauth/oauthtest"
+)
+
+const redirectURL = "http://localhost:4000/user/oauth/mock/callback"
+
+func discover(t *testing.T, issuer *oauthtest.Issuer) oauth.Provider {
+	t.Helper()
+
+	provider, err := oauth.Discover(context.Background(), oauth.Config{
+		Issuer:       issuer.URL,
+		ClientID:     issuer.ClientID,
+		ClientSecret: issuer.ClientSecret,
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// signIn starts a sign in at the provider and returns the code the issuer sent to the callback.
+func signIn(t *testing.T, issuer *oauthtest.Issuer, provider oauth.Provider, state, nonce, verifier string) string {
+	t.Helper()
+
+	authURL := provider.AuthCodeURL(state, nonce, verifier)
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qs := u.Query()
+	if qs.Get("code_challenge_method") != "S256" || qs.Get("code_challenge") == "" || qs.Get("nonce") != nonce {
+		t.Fatalf("authorization URL without PKCE or nonce: %s", authURL)
+	}
+
+	callback, err := issuer.SignIn(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Scheme + "://" + callback.Host + callback.Path; got != redirectURL {
+		t.Fatalf("redirected to %s , want %s", got, redirectURL)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("state %q came back as %q", state, got)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	issuer := oauthtest.NewIssuer(t)
+	provider := discover(t, issuer)
+
+	tests := []struct {
+		name string
+		user oauthtest.User
+		want oauth.Identity
+	}{
+		{
+			name: "verified email",
+			user: oauthtest.User{Subject: "s-1", Email: "ada@example.com", EmailVerified: true, GivenName: "Ada", FamilyName: "Lovelace"},
+			want: oauth.Identity{Subject: "s-1", Email: "ada@example.com", EmailVerified: true, FirstName: "Ada", LastName: "Lovelace"},
+		},
+		{
+			name: "email_verified sent as a string",
+			user: oauthtest.User{Subject: "s-2", Email: "bob@example.com", EmailVerified: "true"},
+			want: oauth.Identity{Subject: "s-2", Email: "bob@example.com", EmailVerified: true},
+		},
+		{
+			name: "unverified email",
+			user: oauthtest.User{Subject: "s-3", Email: "eve@example.com", EmailVerified: false},
+			want: oauth.Identity{Subject: "s-3", Email: "eve@example.com"},
+		},
+		{
+			name: "no email_verified claim",
+			user: oauthtest.User{Subject: "s-4", Email: "joe@example.com"},
+			want: oauth.Identity{Subject: "s-4", Email: "joe@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer.SetUser(tt.user)
+			verifier := oauth.GenerateVerifier()
+			code := signIn(t, issuer, provider, "state", "nonce", verifier)
+
+			identity, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*identity, tt.want) {
+				t.Errorf("got %+v , want %+v", *identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	issuer := oauthtest.NewIssuer(t)
+	provider := discover(t, issuer)
+
+	t.Run("another code verifier", func(t *testing.T) {
+		code := signIn(t, issuer, provider, "state", "nonce", oauth.GenerateVerifier())
+
+		_, err := provider.Exchange(context.Background(), code, oauth.GenerateVerifier(), "nonce")
+		if err == nil {
+			t.Fatal("the code was exchanged without its verifier")
+		}
+	})
+
+	t.Run("a code used twice", func(t *testing.T) {
+		verifier := oauth.GenerateVerifier()
+		code := signIn(t, issuer, provider, "state", "nonce", verifier)
+
+		if _, err := provider.Exchange(context.Background(), code, verifier, "nonce"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Exchange(context.Background(), code, verifier, "nonce"); err == nil {
+			t.Fatal("the code was exchanged twice")
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		issuer.SetNonce("replayed-nonce")
+		defer issuer.SetNonce("")
+
+		verifier := oauth.GenerateVerifier()
+		code := signIn(t, issuer, provider, "state", "nonce", verifier)
+
+		_, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+		if !errors.Is(err, oauth.ErrInvalidIDToken) {
+			t.Fatalf("got %v , want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("token of another client", func(t *testing.T) {
+		other, err := oauth.Discover(context.Background(), oauth.Config{
+			Issuer:       issuer.URL,
+			ClientID:     issuer.ClientID,
+			ClientSecret: "wrong-secret",
+			RedirectURL:  redirectURL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifier := oauth.GenerateVerifier()
+		code := signIn(t, issuer, other, "state", "nonce", verifier)
+		if _, err = other.Exchange(context.Background(), code, verifier, "nonce"); err == nil {
+			t.Fatal("the code was exchanged with a wrong client secret")
+		}
+	})
+}
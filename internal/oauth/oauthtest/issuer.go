@@ -0,0 +1,282 @@
+// Package oauthtest is a mock OpenID Connect provider for tests , the sign in flow runs against it
+// exactly like against a real issuer.
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "oauthtest"
+
+// User is who signs in at the issuer , the claims of the ID tokens it hands out.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified interface{} // a bool , or a string like some providers send
+	GivenName     string
+	FamilyName    string
+}
+
+// grant is an authorization code waiting to be exchanged.
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        User
+}
+
+// Issuer serves discovery , the authorization and token endpoints and the JWKS. The authorization
+// endpoint signs in the current user straight away , there is no login page.
+type Issuer struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	user  User
+	nonce string
+	codes map[string]grant
+}
+
+// NewIssuer starts an issuer , closed when the test ends.
+func NewIssuer(t testing.TB) *Issuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &Issuer{
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		key:          key,
+		user:         User{Subject: "subject-1", Email: "user@example.com", EmailVerified: true},
+		codes:        map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("GET /authorize", i.authorize)
+	mux.HandleFunc("POST /token", i.token)
+	mux.HandleFunc("GET /jwks", i.jwks)
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+// SetUser changes who signs in from now on.
+func (i *Issuer) SetUser(u User) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.user = u
+}
+
+// SetNonce makes the ID tokens carry nonce instead of the one of the authorization request , "" goes back
+// to the right one.
+func (i *Issuer) SetNonce(nonce string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.nonce = nonce
+}
+
+// SignIn opens an authorization URL as the user's browser would and returns the callback URL the issuer
+// redirects to.
+func (i *Issuer) SignIn(authURL string) (*url.URL, error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("oauthtest: authorize answered %s", res.Status)
+	}
+	return url.Parse(res.Header.Get("Location"))
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := i.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize checks the request like a real issuer would , PKCE with S256 is required.
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	switch {
+	case qs.Get("client_id") != i.ClientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case qs.Get("response_type") != "code":
+		http.Error(w, "response_type must be code", http.StatusBadRequest)
+		return
+	case qs.Get("code_challenge_method") != "S256" || qs.Get("code_challenge") == "":
+		http.Error(w, "a S256 code_challenge is required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(qs.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.codes[code] = grant{
+		redirectURI: redirect.String(),
+		challenge:   qs.Get("code_challenge"),
+		nonce:       qs.Get("nonce"),
+		user:        i.user,
+	}
+	i.mu.Unlock()
+
+	callback := redirect.Query()
+	callback.Set("code", code)
+	callback.Set("state", qs.Get("state"))
+	redirect.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges a code once , for the client that asked for it and the verifier of its challenge.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != i.ClientID || clientSecret != i.ClientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	i.mu.Lock()
+	code := r.PostForm.Get("code")
+	g, ok := i.codes[code]
+	delete(i.codes, code)
+	nonce := i.nonce
+	i.mu.Unlock()
+
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(g.challenge)) != 1 {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	if nonce == "" {
+		nonce = g.nonce
+	}
+	idToken, err := i.idToken(g.user, nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (i *Issuer) idToken(u User, nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   i.URL,
+		"aud":   i.ClientID,
+		"sub":   u.Subject,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+		"email": u.Email,
+	}
+	if u.EmailVerified != nil {
+		claims["email_verified"] = u.EmailVerified
+	}
+	if u.GivenName != "" {
+		claims["given_name"] = u.GivenName
+	}
+	if u.FamilyName != "" {
+		claims["family_name"] = u.FamilyName
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(i.key)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	status := http.StatusBadRequest
+	if code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
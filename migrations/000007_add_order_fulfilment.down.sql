@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_orders_stripe_payment_id;
+DROP INDEX IF EXISTS idx_orders_created_at;
+DROP INDEX IF EXISTS idx_orders_status;
+DROP INDEX IF EXISTS idx_orders_user_id;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS cancelled_at,
+    DROP COLUMN IF EXISTS fulfilled_at,
+    DROP COLUMN IF EXISTS tracking_number,
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_orders_user_history ON orders (user_id, created_at DESC, id DESC);
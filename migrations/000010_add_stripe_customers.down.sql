@@ -0,0 +1,5 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS credit_card_id;
+
+ALTER TABLE credit_cards DROP COLUMN IF EXISTS stripe_payment_method_id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS stripe_customer_id;
@@ -0,0 +1,8 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS stripe_customer_id VARCHAR(255);
+
+ALTER TABLE credit_cards
+    ADD COLUMN IF NOT EXISTS stripe_payment_method_id VARCHAR(255);
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS credit_card_id INTEGER REFERENCES credit_cards(id) ON DELETE SET NULL;
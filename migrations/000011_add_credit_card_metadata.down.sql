@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS notifications_outbox;
+
+DROP INDEX IF EXISTS idx_credit_cards_one_default;
+
+ALTER TABLE credit_cards
+    DROP COLUMN IF EXISTS expiry_status,
+    DROP COLUMN IF EXISTS is_default,
+    DROP COLUMN IF EXISTS last4,
+    DROP COLUMN IF EXISTS brand;
+
+ALTER TABLE credit_cards
+    ADD CONSTRAINT chk_expiry_date CHECK (expiry_date > CURRENT_DATE) NOT VALID;
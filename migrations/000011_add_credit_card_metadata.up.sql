@@ -0,0 +1,23 @@
+-- a CHECK constraint is re-evaluated on every UPDATE , so it made stored cards impossible to
+-- update once they expired. The future expiry is now checked by the application on insert.
+ALTER TABLE credit_cards DROP CONSTRAINT IF EXISTS chk_expiry_date;
+
+ALTER TABLE credit_cards
+    ADD COLUMN IF NOT EXISTS brand VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS last4 CHAR(4),
+    ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS expiry_status VARCHAR(20) NOT NULL DEFAULT 'valid';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_credit_cards_one_default ON credit_cards (user_id) WHERE is_default;
+
+CREATE TABLE IF NOT EXISTS notifications_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMPTZ,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_outbox_unsent ON notifications_outbox (created_at) WHERE sent_at IS NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS wishlist_items (
+    user_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    notify_back_in_stock BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, product_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_wishlist_items_product_id ON wishlist_items (product_id) WHERE notify_back_in_stock;
@@ -0,0 +1,5 @@
+ALTER TABLE products
+    DROP COLUMN IF EXISTS rating_sum,
+    DROP COLUMN IF EXISTS rating_count;
+
+DROP TABLE IF EXISTS product_reviews;
@@ -0,0 +1,3 @@
+ALTER TABLE products DROP COLUMN IF EXISTS low_stock_threshold;
+
+DROP TABLE IF EXISTS inventory_movements;
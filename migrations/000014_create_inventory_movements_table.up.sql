@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS inventory_movements (
+    id BIGSERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL,
+    quantity_delta INTEGER NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    actor_user_id INTEGER,
+    order_id INTEGER,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT chk_movement_reason CHECK (reason IN ('sale', 'restock', 'return', 'adjustment')),
+    CONSTRAINT chk_movement_delta CHECK (quantity_delta <> 0),
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_product ON inventory_movements (product_id, created_at DESC);
+
+-- 0 disables the low stock alert of a product.
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS low_stock_threshold INTEGER NOT NULL DEFAULT 0;
+
+-- opening balance , so the ledger of every existing product sums up to its current stock.
+INSERT INTO inventory_movements (product_id, quantity_delta, reason, note)
+SELECT id, inventory_count, 'adjustment', 'opening balance'
+FROM products
+WHERE inventory_count <> 0;
@@ -0,0 +1,5 @@
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS sku VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_sku ON products (sku) WHERE sku IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_products_lower_name ON products (lower(name));
@@ -0,0 +1,18 @@
+-- per product and per day (UTC) totals of the orders counted as sales (paid and fulfilled) ,
+-- kept up to date by the order status changes and rebuilt by cmd/backfill.
+CREATE TABLE IF NOT EXISTS daily_product_sales (
+    day DATE NOT NULL,
+    product_id INTEGER NOT NULL,
+    units INTEGER NOT NULL DEFAULT 0,
+    revenue NUMERIC(14, 2) NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, product_id),
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+-- the per day totals , orders can not be summed up from the per product rows.
+CREATE TABLE IF NOT EXISTS daily_sales (
+    day DATE PRIMARY KEY,
+    orders INTEGER NOT NULL DEFAULT 0,
+    units INTEGER NOT NULL DEFAULT 0,
+    revenue NUMERIC(14, 2) NOT NULL DEFAULT 0
+);
@@ -0,0 +1,11 @@
+-- failed logins , keyed by the (lower case) email so unknown emails are throttled exactly like real accounts.
+CREATE TABLE IF NOT EXISTS login_failures (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip INET,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_email ON login_failures (email, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_failures_ip ON login_failures (ip, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_failures_created_at ON login_failures (created_at);
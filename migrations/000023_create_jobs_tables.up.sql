@@ -0,0 +1,37 @@
+-- the job queue , workers claim due rows with FOR UPDATE SKIP LOCKED.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(16) NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    unique_key TEXT,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMPTZ,
+    CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'succeeded', 'dead'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_queued ON jobs (run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_running ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_jobs_succeeded ON jobs (finished_at) WHERE status = 'succeeded';
+CREATE INDEX IF NOT EXISTS idx_jobs_status_created ON jobs (status, created_at DESC);
+-- at most one queued job per unique key.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique_queued ON jobs (unique_key) WHERE status = 'queued' AND unique_key IS NOT NULL;
+
+-- the next due time of every recurring job , the instance that locks the row enqueues it.
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name VARCHAR(100) PRIMARY KEY,
+    next_run_at TIMESTAMPTZ NOT NULL,
+    last_run_at TIMESTAMPTZ
+);
+
+-- webhook deliveries are sent by the queue now , hand it the ones still pending.
+INSERT INTO jobs (kind, payload, unique_key, run_at)
+SELECT 'webhook.deliver', jsonb_build_object('delivery_id', id), 'webhook.deliver:' || id, next_attempt_at
+FROM webhook_deliveries
+WHERE status = 'pending';
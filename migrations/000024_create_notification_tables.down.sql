@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS notification_preferences;
+DROP INDEX IF EXISTS idx_notifications_outbox_user;
+ALTER TABLE notifications_outbox DROP COLUMN IF EXISTS read_at;
@@ -0,0 +1,15 @@
+-- notifications_outbox is the in-app inbox too , sent_at is when the email went out.
+-- The notifications written before this migration are left unsent , they are too old to email now.
+ALTER TABLE notifications_outbox ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_outbox_user ON notifications_outbox (user_id, created_at DESC);
+
+-- the channels a user wants a kind of notification on , no row means all of them.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(100) NOT NULL,
+    email BOOLEAN NOT NULL DEFAULT TRUE,
+    in_app BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind)
+);